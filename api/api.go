@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"io"
@@ -28,12 +29,19 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
 // Config represent configuration for API endpoints
 type Config struct {
-	Address    string
+	Address string
+	Token   string
+	// CACert verifies the server certificate, ClientCert and ClientKey are
+	// presented to servers verifying client certificates
+	CACert     string
+	ClientCert string
+	ClientKey  string
 	WaitTime   time.Duration
 	HttpClient *http.Client
 }
@@ -47,6 +55,10 @@ func DefaultConfig() *Config {
 	if addr := os.Getenv("GYPSY_ADDR"); addr != "" {
 		config.Address = addr
 	}
+	config.Token = os.Getenv("GYPSY_TOKEN")
+	config.CACert = os.Getenv("GYPSY_CACERT")
+	config.ClientCert = os.Getenv("GYPSY_CLIENT_CERT")
+	config.ClientKey = os.Getenv("GYPSY_CLIENT_KEY")
 	return config
 }
 
@@ -65,6 +77,17 @@ func NewClient(config *Config) (*Client, error) {
 	if config.HttpClient == nil {
 		config.HttpClient = defConfig.HttpClient
 	}
+	tlsConfig, err := util.ClientTLSConfig(config.CACert, config.ClientCert, config.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS configuration: %v", err)
+	}
+	if tlsConfig != nil {
+		transport, ok := config.HttpClient.Transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("TLS options need an http.Transport")
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
 
 	client := &Client{
 		config: *config,
@@ -106,6 +129,9 @@ func (c *Client) newRequest(method, path string, body io.Reader) (*http.Request,
 	req.URL.Host = target.Host
 	req.URL.Scheme = target.Scheme
 	req.Host = target.Host
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	}
 	return req, nil
 }
 
@@ -149,8 +175,9 @@ func (c *Client) CreatePipeline(pipeline *structs.Pipeline) error {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Failed to create pipeline. HTTP Status code: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to create pipeline. HTTP Status code: %d. %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 	return nil
 }
@@ -166,3 +193,75 @@ func (c *Client) DeletePipeline(pipeline string) error {
 	}
 	return nil
 }
+
+// TriggerRun starts a new run of the pipeline and returns its id
+func (c *Client) TriggerRun(pipeline string, request *structs.RunRequest) (int, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		log.Errorf("Failed to convert run request into json. Error:%s\n", err)
+		return 0, err
+	}
+	resp, err := c.Request("POST", "/pipelines/"+pipeline+"/runs", bytes.NewBuffer(data))
+	if err != nil {
+		log.Errorf("Failed to trigger run. Error:%s\n", err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return 0, fmt.Errorf("Failed to trigger run. HTTP Status code: %d", resp.StatusCode)
+	}
+	var run structs.Run
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		log.Errorf("Failed to decode run. Error:%s\n", err)
+		return 0, err
+	}
+	return run.ID, nil
+}
+
+func (c *Client) CancelRun(pipeline string, id int) (*structs.Run, error) {
+	resp, err := c.Request("POST", fmt.Sprintf("/pipelines/%s/runs/%d/cancel", pipeline, id), bytes.NewBuffer(nil))
+	if err != nil {
+		log.Errorf("Failed to cancel run. Error:%s\n", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("Failed to cancel run. HTTP Status code: %d", resp.StatusCode)
+	}
+	run := new(structs.Run)
+	return run, json.NewDecoder(resp.Body).Decode(run)
+}
+
+func (c *Client) GetRun(pipeline string, id int) (*structs.Run, error) {
+	resp, err := c.Request("GET", fmt.Sprintf("/pipelines/%s/runs/%d", pipeline, id), bytes.NewBuffer(nil))
+	if err != nil {
+		log.Errorf("Failed to obtain run details. Error:%s\n", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to obtain run details. HTTP Status code: %d", resp.StatusCode)
+	}
+	run := new(structs.Run)
+	return run, json.NewDecoder(resp.Body).Decode(run)
+}
+
+// StreamLog copies the output of a run to w. With follow, it keeps
+// streaming new output until the run finishes.
+func (c *Client) StreamLog(pipeline string, id int, follow bool, w io.Writer) error {
+	path := fmt.Sprintf("/pipelines/%s/runs/%d/log", pipeline, id)
+	if follow {
+		path += "?follow=true"
+	}
+	resp, err := c.Request("GET", path, bytes.NewBuffer(nil))
+	if err != nil {
+		log.Errorf("Failed to obtain run log. Error:%s\n", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to obtain run log. HTTP Status code: %d", resp.StatusCode)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes of build agents, reported to nomad as the task exit code
+const (
+	ExitSucceeded = 0
+	// ExitFailed is returned for failed, cancelled and timed out builds
+	ExitFailed = 1
+	// ExitErrored is returned when the agent could not build the run
+	ExitErrored = 2
+)
+
+// RunAgent builds the builder's run inside this process: the pipeline is
+// fetched from the server, built in clones of its containers and the result
+// posted back. A non empty container replaces the pipeline container.
+func (c *Builder) RunAgent(container string) int {
+	name := c.Run.PipelineName
+	pipeline, err := c.FetchPipeline(name)
+	if err != nil {
+		log.Errorf("Failed to fetch spec for pipeline %s. Error: %v", name, err)
+		return ExitErrored
+	}
+	if container != "" {
+		pipeline.Container = container
+	}
+	log.Infof("Building run %d of pipeline %s", c.Run.ID, name)
+	c.devBuild(name, pipeline)
+	switch c.Run.Status {
+	case structs.RunSucceeded:
+		return ExitSucceeded
+	case structs.RunFailed, structs.RunCancelled, structs.RunTimedOut:
+		return ExitFailed
+	default:
+		return ExitErrored
+	}
+}
@@ -18,24 +18,58 @@ package build
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/material"
 	"github.com/ranjib/gypsy/structs"
 	"github.com/ranjib/gypsy/util"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/lxc/go-lxc.v2"
 	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+var (
+	// ErrCancelled is returned by builds stopped through Cancel
+	ErrCancelled = errors.New("Build cancelled")
+	// ErrTimedOut is returned by builds stopped by a command or pipeline timeout
+	ErrTimedOut = errors.New("Build timed out")
+)
+
+// diskCheckInterval is how often the disk usage of build environments with
+// a disk limit is measured while commands run
+const diskCheckInterval = 10 * time.Second
+
 type Builder struct {
 	ServerURL string
-	Run       structs.Run
+	// Token authenticates the builder with the server
+	Token string
+	// HTTPClient is used for all requests to the server
+	HTTPClient *http.Client
+	// ArtifactDir stores artifacts on the local host instead of posting them
+	// to the server, when set
+	ArtifactDir string
+	// Output receives command output as it is produced, when set
+	Output     io.Writer
+	Run        structs.Run
+	logs       *LogStreamer
+	mu         sync.Mutex
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+	// reason the build was stopped for, set before cancelled is closed
+	reason error
+	// secrets referenced by the pipeline, by name
+	secrets map[string]string
+	// limits of the build environments, from the pipeline resources
+	limits executor.Limits
 }
 
 func NewBuilder(url, name string, runId int) *Builder {
@@ -45,66 +79,196 @@ func NewBuilder(url, name string, runId int) *Builder {
 			ID:           runId,
 			PipelineName: name,
 		},
+		HTTPClient: http.DefaultClient,
+		cancelled:  make(chan struct{}),
 	}
 }
 
-func BuildPipeline(name string, runId int) int {
-	c := NewBuilder("http://127.0.0.1:5678", name, runId)
-	pipeline, err1 := c.FetchPipeline(name)
-	if err1 != nil {
-		log.Errorf("Failed to fetch spec for pipeline %s. Error: %v", name, err1)
+// ConfigureTLS sets up HTTPS requests to the server: caFile verifies the
+// server certificate, certFile and keyFile are the agent's client certificate
+func (c *Builder) ConfigureTLS(caFile, certFile, keyFile string) error {
+	client, err := util.HTTPClient(caFile, certFile, keyFile)
+	if err != nil {
+		log.Errorf("Failed to configure TLS. Error: %v", err)
+		return err
+	}
+	c.HTTPClient = client
+	return nil
+}
+
+// Cancel stops the build: running commands are killed and no further stage
+// or job is started
+func (c *Builder) Cancel() {
+	c.stop(ErrCancelled)
+}
+
+func (c *Builder) stop(reason error) {
+	c.cancelOnce.Do(func() {
+		log.Infof("Stopping run %d of pipeline %s: %v", c.Run.ID, c.Run.PipelineName, reason)
+		c.reason = reason
+		close(c.cancelled)
+	})
+}
+
+func (c *Builder) isCancelled() bool {
+	select {
+	case <-c.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// watchCancellation polls the server for the run status and cancels the build
+// once the run is marked cancelling, until stop is closed
+func (c *Builder) watchCancellation(stop chan struct{}) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		status, err := c.fetchStatus()
+		if err != nil {
+			log.Warnf("Failed to check status of run %d. Error: %v", c.Run.ID, err)
+			continue
+		}
+		if status == structs.RunCancelling || status == structs.RunCancelled {
+			c.Cancel()
+			return
+		}
+	}
+}
+
+func (c *Builder) fetchStatus() (structs.RunStatus, error) {
+	resp, err := c.get(c.runURL())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Non 200 response from server. Return code: %d", resp.StatusCode)
+	}
+	var run structs.Run
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return "", err
+	}
+	return run.Status, nil
+}
+
+// BuildPipeline schedules a run of a pipeline against the server at
+// serverURL. TLS settings are read from the GYPSY_CACERT, GYPSY_CLIENT_CERT
+// and GYPSY_CLIENT_KEY environment variables.
+func BuildPipeline(serverURL, name string, runId int, token string) (*NomadJob, error) {
+	c := NewBuilder(serverURL, name, runId)
+	c.Token = token
+	if err := c.ConfigureTLS(os.Getenv("GYPSY_CACERT"), os.Getenv("GYPSY_CLIENT_CERT"), os.Getenv("GYPSY_CLIENT_KEY")); err != nil {
+		return nil, err
+	}
+	return c.Schedule()
+}
+
+// Build schedules the builder's run, returning an exit code
+func (c *Builder) Build() int {
+	if _, err := c.Schedule(); err != nil {
 		return 1
 	}
-	//c.devBuild(name, pipeline)
-	c.nomadicBuild(pipeline, runId)
-	log.Info("Successfully downloaded pipeline spec. Creating container for ", pipeline.Name)
 	return 0
 }
 
-func (c *Builder) nomadicBuild(pipeline *structs.Pipeline, runId int) int {
-	job, err := c.CreateNomadJob(pipeline, runId)
+// Schedule fetches the pipeline of the builder's run from the server and
+// submits the nomad job building it. The job runs `gypsy agent`, see RunAgent.
+func (c *Builder) Schedule() (*NomadJob, error) {
+	name := c.Run.PipelineName
+	pipeline, err1 := c.FetchPipeline(name)
+	if err1 != nil {
+		log.Errorf("Failed to fetch spec for pipeline %s. Error: %v", name, err1)
+		return nil, err1
+	}
+	log.Info("Successfully downloaded pipeline spec. Scheduling build of ", pipeline.Name)
+	job, err := c.CreateNomadJob(pipeline, c.Run.ID)
 	if err != nil {
 		log.Errorf("Failed to create nomad job for pipeline %s. Error: %v", pipeline.Name, err)
-		return 1
+		return nil, err
+	}
+	if job.Run() != 0 {
+		return nil, fmt.Errorf("Failed to submit nomad job %s", job.Job.ID)
 	}
-	return job.Run()
+	return job, nil
 }
 
 func (c *Builder) devBuild(name string, pipeline *structs.Pipeline) int {
-	container, err := c.CreateContainer(pipeline.Container)
-	if err != nil {
-		log.Errorf("Failed to create container for pipeline %s build. Error: %v", name, err)
-		return 1
+	if err := c.FetchRun(); err != nil {
+		log.Errorf("Failed to fetch run %d of pipeline %s. Error: %v", c.Run.ID, name, err)
+		return ExitErrored
 	}
-	err = c.PerformBuild(container, pipeline.Scripts)
-	if err != nil {
-		log.Errorf("Failed to build pipeline %s. Error: %v", name, err)
-		return 1
+	if c.Run.Status == structs.RunCancelling {
+		log.Infof("Run %d of pipeline %s was cancelled before it started", c.Run.ID, name)
+		c.Run.SetStatus(structs.RunCancelled)
+		c.PostRunData()
+		return ExitFailed
 	}
-	if len(pipeline.Artifacts) > 0 {
-		err = c.UploadArtifacts(container, pipeline.Artifacts)
-		if err != nil {
-			log.Errorf("Failed to upload pipeline %s artifact. Error: %v", name, err)
-			return 1
+	c.Run.SetStatus(structs.RunRunning)
+	if err := c.PostRunData(); err != nil {
+		if e, ok := err.(*responseError); ok && e.StatusCode == http.StatusConflict {
+			// the server rejects the transition when the run was cancelled in the meantime
+			log.Infof("Run %d of pipeline %s was cancelled before it started", c.Run.ID, name)
+			c.Run.SetStatus(structs.RunCancelled)
+			c.PostRunData()
+			return ExitFailed
+		}
+		log.Errorf("Failed to mark run %d of pipeline %s as running. Error: %v", c.Run.ID, name, err)
+		c.Run.SetStatus(structs.RunErrored)
+		c.PostRunData()
+		return ExitErrored
+	}
+	if len(pipeline.SecretNames()) > 0 {
+		if err := c.FetchSecrets(); err != nil {
+			log.Errorf("Failed to fetch secrets of pipeline %s. Error: %v", name, err)
+			c.Run.SetStatus(structs.RunErrored)
+			c.PostRunData()
+			return ExitErrored
+		}
+	}
+	stop := make(chan struct{})
+	go c.watchCancellation(stop)
+	c.logs = NewLogStreamer(c.HTTPClient, c.runURL()+"/log", c.Token)
+	err := c.PerformBuild(pipeline)
+	c.logs.Close()
+	close(stop)
+	if err != nil && c.isCancelled() {
+		log.Infof("Run %d of pipeline %s was stopped: %v", c.Run.ID, name, c.reason)
+		if c.reason == ErrTimedOut {
+			c.Run.SetStatus(structs.RunTimedOut)
+		} else {
+			c.Run.SetStatus(structs.RunCancelled)
 		}
+		c.PostRunData()
+		return ExitFailed
 	}
-	err = c.DestroyContainer(container)
 	if err != nil {
 		log.Errorf("Failed to build pipeline %s. Error: %v", name, err)
-		return 1
+		c.Run.SetStatus(structs.RunFailed)
+		c.PostRunData()
+		return ExitFailed
 	}
-	c.Run.Success = true
+	c.Run.SetStatus(structs.RunSucceeded)
 	c.PostRunData()
-	return 0
+	return ExitSucceeded
 }
 
 func (c *Builder) FetchPipeline(name string) (*structs.Pipeline, error) {
-	resp, err := http.Get(c.ServerURL + "/pipelines/" + name)
+	resp, err := c.get(c.ServerURL + "/pipelines/" + name)
 	if err != nil {
 		log.Errorf("Failed to fetch pipeline spec from server. Error: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Non 200 response from server. Return code: %d", resp.StatusCode)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Errorf("Failed to read response body. Error: %v", err)
@@ -118,22 +282,258 @@ func (c *Builder) FetchPipeline(name string) (*structs.Pipeline, error) {
 	return pipeline, nil
 }
 
-func (c *Builder) CreateContainer(original string) (*lxc.Container, error) {
-	cloned, err := util.UUID()
+// get performs an authenticated GET request against the server
+func (c *Builder) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Errorf("Failed to generate uuid. Error: %v", err)
 		return nil, err
 	}
-	ct, err := util.CloneAndStartContainer(original, cloned)
+	util.SetToken(req, c.Token)
+	return c.HTTPClient.Do(req)
+}
+
+func (c *Builder) runURL() string {
+	return c.ServerURL + "/pipelines/" + c.Run.PipelineName + "/runs/" + strconv.Itoa(c.Run.ID)
+}
+
+// FetchRun loads the run record created by the server when the run was
+// triggered (branch, tag etc.). Runs unknown to the server are left as is.
+func (c *Builder) FetchRun() error {
+	resp, err := c.get(c.runURL())
 	if err != nil {
-		log.Errorf("Failed to clone container %s as %s. Error: %v", original, cloned, err)
-		return nil, err
+		log.Errorf("Failed to fetch run from server. Error: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		log.Warnf("Run %d of pipeline %s not found on server", c.Run.ID, c.Run.PipelineName)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Non 200 response from server. Return code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&c.Run); err != nil {
+		log.Errorf("Failed to decode run data. Error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FetchSecrets loads the values of the secrets referenced by the pipeline
+func (c *Builder) FetchSecrets() error {
+	resp, err := c.get(c.runURL() + "/secrets")
+	if err != nil {
+		log.Errorf("Failed to fetch secrets from server. Error: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Non 200 response from server. Return code: %d. %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(&c.secrets)
+}
+
+// PerformBuild runs the pipeline stages in dependency order. Jobs within a
+// stage are built in parallel, each in its own container. The build stops at
+// the first failed stage.
+func (c *Builder) PerformBuild(pipeline *structs.Pipeline) error {
+	stages, err := pipeline.OrderedStages()
+	if err != nil {
+		log.Errorf("Failed to order stages of pipeline %s. Error: %v", pipeline.Name, err)
+		return err
+	}
+	timeout, err := structs.ParseTimeout(pipeline.Timeout)
+	if err != nil {
+		log.Errorf("Invalid timeout for pipeline %s. Error: %v", pipeline.Name, err)
+		return err
+	}
+	c.limits, err = executor.NewLimits(pipeline.Resources)
+	if err != nil {
+		log.Errorf("Invalid resources for pipeline %s. Error: %v", pipeline.Name, err)
+		return err
+	}
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			log.Warnf("Pipeline %s exceeded its timeout of %s", pipeline.Name, timeout)
+			c.stop(ErrTimedOut)
+		})
+		defer timer.Stop()
+	}
+	for _, stage := range stages {
+		if c.isCancelled() {
+			return c.reason
+		}
+		log.Infof("Building stage '%s' of pipeline %s", stage.Name, pipeline.Name)
+		stageRun := structs.StageRun{
+			Name: stage.Name,
+			Jobs: make([]structs.JobRun, len(stage.Jobs)),
+		}
+		errs := make([]error, len(stage.Jobs))
+		var wg sync.WaitGroup
+		for i, job := range stage.Jobs {
+			stageRun.Jobs[i].Name = job.Name
+			wg.Add(1)
+			go func(i int, job structs.Job) {
+				defer wg.Done()
+				errs[i] = c.buildJob(pipeline, job, &stageRun.Jobs[i])
+			}(i, job)
+		}
+		wg.Wait()
+		stageRun.Success = true
+		for i, jobRun := range stageRun.Jobs {
+			c.Run.Stdout = strings.Join([]string{c.Run.Stdout, jobRun.Stdout}, "\n")
+			c.Run.Stderr = strings.Join([]string{c.Run.Stderr, jobRun.Stderr}, "\n")
+			if errs[i] != nil {
+				log.Errorf("Job '%s' of stage '%s' failed. Error: %v", jobRun.Name, stage.Name, errs[i])
+				stageRun.Success = false
+			}
+		}
+		c.Run.Stages = append(c.Run.Stages, stageRun)
+		if !stageRun.Success {
+			return fmt.Errorf("Stage '%s' failed", stage.Name)
+		}
+	}
+	return nil
+}
+
+func (c *Builder) buildJob(pipeline *structs.Pipeline, job structs.Job, jobRun *structs.JobRun) error {
+	if c.isCancelled() {
+		return c.reason
+	}
+	exec, err := executor.Get(pipeline.Executor)
+	if err != nil {
+		log.Errorf("Failed to find executor of job %s. Error: %v", job.Name, err)
+		return err
+	}
+	clone := pipeline.Clone
+	if clone == "" {
+		clone = c.Run.Clone
+	}
+	env, err := exec.Create(job.Container, executor.CreateOptions{Limits: c.limits, Clone: clone})
+	if err != nil {
+		log.Errorf("Failed to create environment for job %s. Error: %v", job.Name, err)
+		return err
+	}
+	defer func() {
+		if e := env.Destroy(); e != nil {
+			log.Errorf("Failed to destroy environment of job %s. Error: %v", job.Name, e)
+		}
+	}()
+	if err := c.CheckoutMaterials(env, pipeline.Materials); err != nil {
+		log.Errorf("Failed to checkout materials for job %s. Error: %v", job.Name, err)
+		return err
+	}
+	if err := c.RunCommands(env, job.Scripts, c.environment(pipeline), jobRun); err != nil {
+		return err
+	}
+	if len(job.Artifacts) > 0 {
+		if err := c.UploadArtifacts(env, job.Artifacts); err != nil {
+			log.Errorf("Failed to upload artifacts of job %s. Error: %v", job.Name, err)
+			return err
+		}
+	}
+	jobRun.Success = true
+	return nil
+}
+
+// CheckoutMaterials fetches materials that specify a destination inside the
+// build environment
+func (c *Builder) CheckoutMaterials(env executor.Environment, materials []structs.Material) error {
+	for i, spec := range materials {
+		if spec.Dest == "" {
+			continue
+		}
+		spec = material.Select(spec, c.Run.Branch, c.Run.Tag)
+		m, err := material.New(spec, os.TempDir())
+		if err != nil {
+			log.Errorf("Failed to initialize %s material. Error: %v", spec.Type, err)
+			return err
+		}
+		revision, err := c.materialRevision(i, spec, m)
+		if err != nil {
+			log.Errorf("Failed to resolve revision of %s material %s. Error: %v", spec.Type, spec.URI, err)
+			return err
+		}
+		dest := env.HostPath(spec.Dest)
+		log.Infof("Checking out %s material %s at %s", spec.Type, spec.URI, spec.Dest)
+		if err := m.Checkout(revision, dest); err != nil {
+			log.Errorf("Failed to checkout %s material %s. Error: %v", spec.Type, spec.URI, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// materialRevision returns the revision to build: the requested one for the
+// first material, the one recorded when the run was triggered or the latest.
+// The revision is recorded on the run, so parallel jobs build the same one.
+func (c *Builder) materialRevision(index int, spec structs.Material, m material.Material) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := material.Key(spec)
+	if c.Run.Revisions == nil {
+		c.Run.Revisions = make(map[string]string)
+	}
+	if index == 0 && c.Run.Revision != "" {
+		c.Run.Revisions[key] = c.Run.Revision
+	}
+	if revision, ok := c.Run.Revisions[key]; ok {
+		return revision, nil
+	}
+	revision, err := m.Latest()
+	if err != nil {
+		return "", err
+	}
+	c.Run.Revisions[key] = revision
+	return revision, nil
+}
+
+// environment returns the build environment: the base environment of the
+// run, overridden by pipeline env and parameters, the run's env and
+// parameters, and finally the GYPSY_* variables
+func (c *Builder) environment(pipeline *structs.Pipeline) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	base := c.Run.BaseEnv
+	if base == nil {
+		base = util.EnvMap(util.MinimalEnv())
+	}
+	env := make(map[string]string)
+	for _, layer := range []map[string]string{base, pipeline.Env, pipeline.Parameters, c.Run.Env, c.Run.Parameters} {
+		for k, v := range layer {
+			if name, ok := structs.SecretRef(v); ok {
+				if secret, found := c.secrets[name]; found {
+					v = secret
+				}
+			}
+			env[k] = v
+		}
+	}
+	revision := c.Run.Revision
+	if revision == "" && len(pipeline.Materials) > 0 {
+		spec := material.Select(pipeline.Materials[0], c.Run.Branch, c.Run.Tag)
+		revision = c.Run.Revisions[material.Key(spec)]
 	}
-	return ct, nil
+	env["GYPSY_PIPELINE"] = pipeline.Name
+	env["GYPSY_RUN_ID"] = strconv.Itoa(c.Run.ID)
+	env["GYPSY_REVISION"] = revision
+	env["GYPSY_SERVER_URL"] = c.ServerURL
+	return util.EnvList(env)
 }
 
-func (c *Builder) PerformBuild(container *lxc.Container, commands []structs.Command) error {
-	for _, cmd := range commands {
+func (c *Builder) RunCommands(buildEnv executor.Environment, commands []structs.Command, env []string, jobRun *structs.JobRun) error {
+	for i, cmd := range commands {
+		args, err := commandArgs(buildEnv, i, cmd)
+		if err != nil {
+			log.Errorf("Failed to prepare command: '%s'. Error: %v", describe(cmd), err)
+			return err
+		}
+		timeout, err := structs.ParseTimeout(cmd.Timeout)
+		if err != nil {
+			log.Errorf("Invalid timeout for command: '%s'. Error: %v", describe(cmd), err)
+			return err
+		}
 		var wg sync.WaitGroup
 		stdoutReader, stdoutWriter, err := os.Pipe()
 		outWriter := new(bytes.Buffer)
@@ -150,32 +550,38 @@ func (c *Builder) PerformBuild(container *lxc.Container, commands []structs.Comm
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			_, err := io.Copy(outWriter, stdoutReader)
-			if err != nil {
+			out := c.output(outWriter, jobRun.Name, "stdout")
+			if _, err := io.Copy(out, stdoutReader); err != nil {
 				log.Errorf("Failed to copy stdout. Error: %v", err)
 			}
+			if err := out.Close(); err != nil {
+				log.Errorf("Failed to write stdout. Error: %v", err)
+			}
 		}()
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			_, err := io.Copy(errWriter, stderrReader)
-			if err != nil {
+			out := c.output(errWriter, jobRun.Name, "stderr")
+			if _, err := io.Copy(out, stderrReader); err != nil {
 				log.Errorf("Failed to copy stderr. Error: %v", err)
 			}
+			if err := out.Close(); err != nil {
+				log.Errorf("Failed to write stderr. Error: %v", err)
+			}
 		}()
 
-		log.Infof("Executing command: '%s'", cmd.Command)
+		log.Infof("Executing command: '%s'", describe(cmd))
 		cwd := "/root"
 		if cmd.Cwd != "" {
 			cwd = cmd.Cwd
 		}
-		options := lxc.DefaultAttachOptions
-		options.Env = util.MinimalEnv()
-		options.StdoutFd = stdoutWriter.Fd()
-		options.StderrFd = stderrWriter.Fd()
-		options.ClearEnv = true
-		options.Cwd = cwd
-		exitCode, err := container.RunCommandStatus(strings.Fields(cmd.Command), options)
+		options := executor.Options{
+			Env:    env,
+			Cwd:    buildEnv.Path(cwd),
+			Stdout: stdoutWriter,
+			Stderr: stderrWriter,
+		}
+		exitCode, err := c.runAttached(buildEnv, args, options, timeout)
 		if e := stdoutWriter.Close(); e != nil {
 			log.Errorf("Failed to close stdout pipe. Error: %v", e)
 		}
@@ -183,25 +589,125 @@ func (c *Builder) PerformBuild(container *lxc.Container, commands []structs.Comm
 			log.Errorf("Failed to close stderr pipe. Error: %v", e)
 		}
 		wg.Wait()
-		c.Run.Stdout = strings.Join([]string{c.Run.Stdout, outWriter.String()}, "\n")
-		c.Run.Stderr = strings.Join([]string{c.Run.Stderr, errWriter.String()}, "\n")
+		jobRun.Stdout = strings.Join([]string{jobRun.Stdout, outWriter.String()}, "\n")
+		jobRun.Stderr = strings.Join([]string{jobRun.Stderr, errWriter.String()}, "\n")
 		if err != nil {
-			log.Errorf("Failed to execute command: '%s'. Error: %v", cmd.Command, err)
+			log.Errorf("Failed to execute command: '%s'. Error: %v", describe(cmd), err)
 			return err
 		}
 		if exitCode != 0 {
-			log.Errorf("Failed to execute command: '%s'. Exit code: %d", cmd.Command, exitCode)
+			log.Errorf("Failed to execute command: '%s'. Exit code: %d", describe(cmd), exitCode)
 			return fmt.Errorf("Exit code:%d", exitCode)
 		}
+		if err := c.checkDiskQuota(buildEnv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAttached runs a command inside the build environment and waits for it.
+// The command is killed when the build is stopped. A command exceeding its
+// timeout stops the whole build; remaining processes of the command die with
+// the environment.
+func (c *Builder) runAttached(env executor.Environment, args []string, options executor.Options, timeout time.Duration) (int, error) {
+	if c.isCancelled() {
+		return -1, c.reason
+	}
+	proc, err := env.Start(args, options)
+	if err != nil {
+		return -1, err
+	}
+	type result struct {
+		exitCode int
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		exitCode, err := proc.Wait()
+		done <- result{exitCode, err}
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var diskCheck <-chan time.Time
+	if c.limits.DiskBytes > 0 {
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		diskCheck = ticker.C
+	}
+	var failure error
+	for failure == nil {
+		select {
+		case r := <-done:
+			return r.exitCode, r.err
+		case <-expired:
+			log.Warnf("Command '%s' exceeded its timeout of %s", strings.Join(args, " "), timeout)
+			c.stop(ErrTimedOut)
+			failure = c.reason
+		case <-c.cancelled:
+			failure = c.reason
+		case <-diskCheck:
+			failure = c.checkDiskQuota(env)
+		}
+	}
+	log.Infof("Killing command '%s' in %s", strings.Join(args, " "), env.Name())
+	if err := proc.Kill(); err != nil {
+		log.Errorf("Failed to kill command '%s'. Error: %v", strings.Join(args, " "), err)
+	}
+	<-done
+	return -1, failure
+}
+
+// checkDiskQuota fails when the files of the build environment exceed the
+// disk limit of the pipeline
+func (c *Builder) checkDiskQuota(env executor.Environment) error {
+	if c.limits.DiskBytes <= 0 {
+		return nil
+	}
+	usage, err := env.DiskUsage()
+	if err != nil {
+		log.Warnf("Failed to measure disk usage of %s. Error: %v", env.Name(), err)
+		return nil
+	}
+	if usage > c.limits.DiskBytes {
+		log.Warnf("Build environment %s uses %d bytes, more than its disk limit", env.Name(), usage)
+		return fmt.Errorf("Disk quota of %d bytes exceeded", c.limits.DiskBytes)
 	}
 	return nil
 }
 
-func (c *Builder) UploadArtifacts(container *lxc.Container, artifacts []structs.Artifact) error {
+// output tees command output to the log streamer, when streaming, and to
+// the builder's output. Secret values are masked line by line before the
+// output leaves the builder, closing the writer flushes the last line.
+func (c *Builder) output(w io.Writer, job, stream string) io.WriteCloser {
+	writers := []io.Writer{w}
+	if c.logs != nil {
+		writers = append(writers, c.logs.Writer(job, stream))
+	}
+	if c.Output != nil {
+		writers = append(writers, c.Output)
+	}
+	return &util.MaskingWriter{W: io.MultiWriter(writers...), Mask: util.SecretMasker(c.secrets)}
+}
+
+func (c *Builder) UploadArtifacts(env executor.Environment, artifacts []structs.Artifact) error {
 	for _, artifact := range artifacts {
+		if c.ArtifactDir != "" {
+			dest := filepath.Join(c.ArtifactDir, artifact.Name)
+			log.Infof("Copying artifact %s to %s", artifact.Path, dest)
+			if err := env.CopyFile(artifact.Path, dest); err != nil {
+				log.Errorf("Failed to copy artifact. Error: %v", err)
+				return err
+			}
+			continue
+		}
 		url := c.ServerURL + "/pipelines/" + c.Run.PipelineName + "/runs/" + strconv.Itoa(c.Run.ID) + "/artifacts/" + artifact.Name
 		log.Infof("Making http post request against '%s' with run data", url)
-		if err := util.PostFileFromContainer(container, artifact.Path, url); err != nil {
+		if err := c.postArtifact(env, artifact.Path, url); err != nil {
 			log.Errorf("Failed to post artifact. Error: %v", err)
 			return err
 		}
@@ -209,14 +715,28 @@ func (c *Builder) UploadArtifacts(container *lxc.Container, artifacts []structs.
 	return nil
 }
 
+// postArtifact copies a file out of the build environment and posts it
+func (c *Builder) postArtifact(env executor.Environment, path, url string) error {
+	tmp, err := ioutil.TempFile("", "gypsy-artifact-")
+	if err != nil {
+		log.Errorf("Failed to create temporary file. Error: %v", err)
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := env.CopyFile(path, tmp.Name()); err != nil {
+		return err
+	}
+	return util.PostFile(c.HTTPClient, tmp.Name(), url, c.Token)
+}
+
 func (c *Builder) PostRunData() error {
-	httpClient := &http.Client{}
 	payload, err := json.Marshal(c.Run)
 	if err != nil {
 		log.Errorf("Failed to marshal run data. Error: %v", err)
 		return err
 	}
-	log.Info(string(payload[:]))
+	log.Infof("Posting run %d of pipeline '%s' with status '%s'", c.Run.ID, c.Run.PipelineName, c.Run.Status)
 	run_id := strconv.Itoa(c.Run.ID)
 	url := c.ServerURL + "/pipelines/" + c.Run.PipelineName + "/runs/" + run_id
 	log.Infof("Making http post request against '%s' with run data", url)
@@ -225,19 +745,25 @@ func (c *Builder) PostRunData() error {
 		log.Errorf("Failed to create http request. Error: %v", err)
 		return err
 	}
-	resp, err := httpClient.Do(req)
+	util.SetToken(req, c.Token)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		log.Errorf("Failed to make http put request. Error: %v", err)
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &responseError{StatusCode: resp.StatusCode}
+	}
 	return nil
 }
 
-func (c *Builder) DestroyContainer(container *lxc.Container) error {
-	if err := container.Stop(); err != nil {
-		log.Errorf("Failed to stop container %s. Error: %v", container.Name(), err)
-		return err
-	}
-	return container.Destroy()
+// responseError is returned by PostRunData when the server rejects the run
+type responseError struct {
+	StatusCode int
 }
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("Non 200 response from server. Return code: %d", e.StatusCode)
+}
+
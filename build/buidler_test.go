@@ -0,0 +1,160 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// symlinkedEnv measures its disk usage through a symlink to its root, like
+// LXC containers without a root filesystem directory
+type symlinkedEnv struct {
+	link string
+}
+
+func (e *symlinkedEnv) Name() string                    { return e.link }
+func (e *symlinkedEnv) Path(path string) string         { return path }
+func (e *symlinkedEnv) HostPath(path string) string     { return filepath.Join(e.link, path) }
+func (e *symlinkedEnv) CopyFile(src, dest string) error { return nil }
+func (e *symlinkedEnv) Destroy() error                  { return nil }
+func (e *symlinkedEnv) DiskUsage() (int64, error)       { return util.DiskUsage(e.link) }
+func (e *symlinkedEnv) Start(args []string, options executor.Options) (executor.Process, error) {
+	return nil, nil
+}
+
+func TestCheckDiskQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gypsy-quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "big"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := &symlinkedEnv{link: filepath.Join(dir, "root")}
+	if err := os.Symlink(root, env.link); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		disk     int64
+		exceeded bool
+	}{
+		{0, false},
+		{1024, true},
+		{4095, true},
+		{4096, false},
+		{1 << 20, false},
+	}
+	for _, test := range tests {
+		b := NewBuilder("http://localhost:5678", "gypsy", 1)
+		b.limits = executor.Limits{DiskBytes: test.disk}
+		err := b.checkDiskQuota(env)
+		if exceeded := err != nil; exceeded != test.exceeded {
+			t.Errorf("disk limit %d: expected exceeded=%v, got %v", test.disk, test.exceeded, err)
+		}
+	}
+}
+
+func TestOutputMasksSecretsSplitAcrossWrites(t *testing.T) {
+	var mu sync.Mutex
+	var streamed []structs.LogChunk
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var chunks []structs.LogChunk
+		if err := json.NewDecoder(r.Body).Decode(&chunks); err != nil {
+			t.Errorf("invalid log chunks: %v", err)
+		}
+		mu.Lock()
+		streamed = append(streamed, chunks...)
+		mu.Unlock()
+	}))
+	defer server.Close()
+	b := NewBuilder(server.URL, "gypsy", 1)
+	b.secrets = map[string]string{"token": "s3cr3t-t0ken"}
+	b.logs = NewLogStreamer(http.DefaultClient, server.URL, "")
+	buf := new(bytes.Buffer)
+	out := b.output(buf, "default", "stdout")
+	out.Write([]byte("login with s3cr"))
+	// the streamer posts what it has before the rest of the secret arrives
+	b.logs.flush()
+	out.Write([]byte("3t-t0ken\nlast s3cr3t-t0"))
+	b.logs.flush()
+	out.Write([]byte("ken"))
+	out.Close()
+	b.logs.Close()
+	var log string
+	for _, chunk := range streamed {
+		log += chunk.Data
+	}
+	expected := "login with ********\nlast ********"
+	if log != expected {
+		t.Errorf("streamed %q, expected %q", log, expected)
+	}
+	if buf.String() != expected {
+		t.Errorf("recorded %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestDevBuildRejectedRunningUpdate(t *testing.T) {
+	tests := []struct {
+		code   int
+		status structs.RunStatus
+		exit   int
+	}{
+		{http.StatusConflict, structs.RunCancelled, ExitFailed},
+		{http.StatusInternalServerError, structs.RunErrored, ExitErrored},
+		{http.StatusUnauthorized, structs.RunErrored, ExitErrored},
+	}
+	for _, test := range tests {
+		var posted []structs.RunStatus
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				json.NewEncoder(w).Encode(structs.Run{ID: 3, PipelineName: "p", Status: structs.RunScheduled})
+				return
+			}
+			var run structs.Run
+			if err := json.NewDecoder(r.Body).Decode(&run); err != nil {
+				t.Errorf("invalid run data: %v", err)
+			}
+			posted = append(posted, run.Status)
+			if run.Status == structs.RunRunning {
+				w.WriteHeader(test.code)
+			}
+		}))
+		b := NewBuilder(server.URL, "p", 3)
+		exit := b.devBuild("p", &structs.Pipeline{Name: "p"})
+		server.Close()
+		if exit != test.exit {
+			t.Errorf("%d: expected exit code %d, got %d", test.code, test.exit, exit)
+		}
+		if len(posted) != 2 || posted[1] != test.status {
+			t.Errorf("%d: expected run to be marked %s, posted %v", test.code, test.status, posted)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/structs"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var shells = map[string]string{
+	"":     "/bin/bash",
+	"bash": "/bin/bash",
+	"sh":   "/bin/sh",
+}
+
+// describe returns a printable form of a command for logs
+func describe(cmd structs.Command) string {
+	switch {
+	case len(cmd.Args) > 0:
+		return strings.Join(cmd.Args, " ")
+	case cmd.Script != "":
+		return strings.TrimSpace(cmd.Script)
+	}
+	return cmd.Command
+}
+
+// commandArgs returns the argument vector executing a command inside a
+// build environment. Shell commands and scripts are written to a script file
+// under the environment's /tmp, the same way dockerfile builds run their
+// commands.
+func commandArgs(env executor.Environment, index int, cmd structs.Command) ([]string, error) {
+	if len(cmd.Args) > 0 {
+		if cmd.Command != "" || cmd.Script != "" {
+			return nil, fmt.Errorf("Commands can have only one of command, script or args")
+		}
+		return cmd.Args, nil
+	}
+	if cmd.Command != "" && cmd.Script != "" {
+		return nil, fmt.Errorf("Commands can have only one of command, script or args")
+	}
+	if cmd.Shell == "none" {
+		if cmd.Script != "" {
+			return nil, fmt.Errorf("Scripts need a shell")
+		}
+		return strings.Fields(cmd.Command), nil
+	}
+	shell, ok := shells[cmd.Shell]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported shell '%s'", cmd.Shell)
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("#!" + shell + "\n")
+	if cmd.Errexit {
+		buffer.WriteString("set -e\n")
+	}
+	if cmd.Script != "" {
+		buffer.WriteString(cmd.Script)
+	} else {
+		buffer.WriteString(cmd.Command)
+	}
+	buffer.WriteString("\n")
+	script := "/tmp/gypsy-" + strconv.Itoa(index) + ".sh"
+	if err := ioutil.WriteFile(env.HostPath(script), buffer.Bytes(), 0755); err != nil {
+		return nil, err
+	}
+	return []string{shell, env.Path(script)}, nil
+}
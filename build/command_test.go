@@ -0,0 +1,80 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"github.com/ranjib/gypsy/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCommandArgs(t *testing.T) {
+	root, err := ioutil.TempDir("", "gypsy-command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.Mkdir(filepath.Join(root, "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	env := &symlinkedEnv{link: root}
+	tests := []struct {
+		cmd    structs.Command
+		args   []string
+		script string
+	}{
+		{structs.Command{Args: []string{"make", "test"}}, []string{"make", "test"}, ""},
+		{structs.Command{Command: "make  test", Shell: "none"}, []string{"make", "test"}, ""},
+		{structs.Command{Command: "make test"}, []string{"/bin/bash", "/tmp/gypsy-2.sh"}, "#!/bin/bash\nmake test\n"},
+		{structs.Command{Script: "make\nmake test", Shell: "sh", Errexit: true}, []string{"/bin/sh", "/tmp/gypsy-3.sh"}, "#!/bin/sh\nset -e\nmake\nmake test\n"},
+	}
+	for i, test := range tests {
+		args, err := commandArgs(env, i, test.cmd)
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", test.cmd, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%+v: expected args %q, got %q", test.cmd, test.args, args)
+		}
+		if test.script == "" {
+			continue
+		}
+		script, err := ioutil.ReadFile(env.HostPath(args[1]))
+		if err != nil {
+			t.Errorf("%+v: script not written: %v", test.cmd, err)
+		} else if string(script) != test.script {
+			t.Errorf("%+v: expected script %q, got %q", test.cmd, test.script, script)
+		}
+	}
+}
+
+func TestCommandArgsErrors(t *testing.T) {
+	env := &symlinkedEnv{link: "/nonexistent"}
+	for _, cmd := range []structs.Command{
+		{Command: "make", Args: []string{"make"}},
+		{Script: "make", Args: []string{"make"}},
+		{Command: "make", Script: "make"},
+		{Script: "make", Shell: "none"},
+		{Command: "make", Shell: "fish"},
+	} {
+		if _, err := commandArgs(env, 0, cmd); err == nil {
+			t.Errorf("%+v: expected an error", cmd)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+// LocalMaterials replaces the pipeline materials with the content of workDir,
+// checked out at the destinations of the original materials. With dest set
+// workDir is checked out there as well.
+func LocalMaterials(pipeline *structs.Pipeline, workDir, dest string) {
+	for i, m := range pipeline.Materials {
+		pipeline.Materials[i] = structs.Material{
+			Type: "dir",
+			URI:  workDir,
+			Dest: m.Dest,
+		}
+	}
+	if dest != "" {
+		pipeline.Materials = append(pipeline.Materials, structs.Material{
+			Type: "dir",
+			URI:  workDir,
+			Dest: dest,
+		})
+	}
+}
+
+// LocalBuild builds a pipeline on this host without a server. Artifacts are
+// copied to the builder's ArtifactDir and secret references are resolved from environment
+// variables of the same name.
+func (c *Builder) LocalBuild(pipeline *structs.Pipeline) int {
+	if err := os.MkdirAll(c.ArtifactDir, 0755); err != nil {
+		log.Errorf("Failed to create artifact directory %s. Error: %v", c.ArtifactDir, err)
+		return 1
+	}
+	c.secrets = make(map[string]string)
+	for _, name := range pipeline.SecretNames() {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			log.Warnf("Secret %s is not set in the environment", name)
+			continue
+		}
+		c.secrets[name] = value
+	}
+	c.Run.SetStatus(structs.RunRunning)
+	err := c.PerformBuild(pipeline)
+	switch {
+	case err != nil && c.isCancelled():
+		log.Infof("Local build of pipeline %s was stopped: %v", pipeline.Name, c.reason)
+		if c.reason == ErrTimedOut {
+			c.Run.SetStatus(structs.RunTimedOut)
+		} else {
+			c.Run.SetStatus(structs.RunCancelled)
+		}
+	case err != nil:
+		log.Errorf("Failed to build pipeline %s. Error: %v", pipeline.Name, err)
+		c.Run.SetStatus(structs.RunFailed)
+	default:
+		c.Run.SetStatus(structs.RunSucceeded)
+	}
+	if c.Run.Status != structs.RunSucceeded {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const logFlushInterval = time.Second
+
+// LogStreamer ships build output to the server in chunks while the build is
+// running
+type LogStreamer struct {
+	Client  *http.Client
+	URL     string
+	Token   string
+	mu      sync.Mutex
+	pending []structs.LogChunk
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewLogStreamer(client *http.Client, url, token string) *LogStreamer {
+	s := &LogStreamer{
+		Client: client,
+		URL:    url,
+		Token:  token,
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.loop()
+	return s
+}
+
+// Writer returns a writer whose output is streamed as the given job's stream
+func (s *LogStreamer) Writer(job, stream string) io.Writer {
+	return &logWriter{streamer: s, job: job, stream: stream}
+}
+
+// Close flushes pending output and stops streaming
+func (s *LogStreamer) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *LogStreamer) loop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.done:
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *LogStreamer) append(chunk structs.LogChunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.pending); n > 0 && s.pending[n-1].Job == chunk.Job && s.pending[n-1].Stream == chunk.Stream {
+		s.pending[n-1].Data += chunk.Data
+		return
+	}
+	s.pending = append(s.pending, chunk)
+}
+
+func (s *LogStreamer) flush() {
+	s.mu.Lock()
+	chunks := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(chunks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(chunks)
+	if err != nil {
+		log.Errorf("Failed to marshal log chunks. Error: %v", err)
+		return
+	}
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(payload))
+	if err != nil {
+		log.Errorf("Failed to create http request. Error: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	util.SetToken(req, s.Token)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		log.Errorf("Failed to stream log chunks. Error: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Failed to stream log chunks. %v", fmt.Errorf("Non 200 response from server. Return code: %d", resp.StatusCode))
+	}
+}
+
+type logWriter struct {
+	streamer *LogStreamer
+	job      string
+	stream   string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.streamer.append(structs.LogChunk{Job: w.job, Stream: w.stream, Data: string(p)})
+	return len(p), nil
+}
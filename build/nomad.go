@@ -19,82 +19,255 @@ import (
 	"fmt"
 	nomadApi "github.com/hashicorp/nomad/api"
 	nomadStructs "github.com/hashicorp/nomad/nomad/structs"
+	"github.com/ranjib/gypsy/executor"
 	"github.com/ranjib/gypsy/structs"
 	log "github.com/sirupsen/logrus"
 	"strconv"
+	"time"
 )
 
+// Defaults for pipelines that do not configure their nomad jobs
+const (
+	defaultNomadRegion   = "global"
+	defaultNomadPriority = 50
+	defaultNomadCPU      = 1024
+	defaultNomadMemoryMB = 128
+	defaultNomadDiskMB   = 300
+	defaultAgentCommand  = "gypsy"
+	// agents get this long to stop their build once nomad kills the task
+	agentKillTimeout = time.Minute
+)
+
+var defaultNomadDatacenters = []string{"dc1"}
+
 type NomadJob struct {
 	Pipeline *structs.Pipeline
 	Job      *nomadStructs.Job
+	// Address and Region of the nomad cluster the job is submitted to
+	Address string
+	Region  string
 }
 
 func (c *Builder) CreateNomadJob(pipeline *structs.Pipeline, runId int) (*NomadJob, error) {
+	spec := pipeline.Nomad
+	command := spec.Command
+	if command == "" {
+		command = defaultAgentCommand
+	}
 	config := make(map[string]interface{})
-	config["container"] = pipeline.Container
-	config["pipeline"] = pipeline.Name
-	config["run_id"] = strconv.Itoa(runId)
-	config["server_url"] = c.ServerURL
+	config["command"] = command
+	config["args"] = []string{
+		"agent",
+		"-container", pipeline.Container,
+		"-pipeline", pipeline.Name,
+		"-run_id", strconv.Itoa(runId),
+		"-address", c.ServerURL,
+	}
 	resources := &nomadStructs.Resources{
-		CPU:      1024,
-		MemoryMB: 128,
+		CPU:      spec.Resources.CPU,
+		MemoryMB: spec.Resources.MemoryMB,
+		DiskMB:   spec.Resources.DiskMB,
+	}
+	// pipeline resources apply unless the nomad resources are set
+	limits, err := executor.NewLimits(pipeline.Resources)
+	if err != nil {
+		log.Errorf("Invalid resources for pipeline %s. Error: %v", pipeline.Name, err)
+		return nil, err
+	}
+	if resources.CPU == 0 {
+		resources.CPU = limits.CPUShares
+	}
+	if resources.MemoryMB == 0 {
+		resources.MemoryMB = int(limits.MemoryBytes >> 20)
+	}
+	logConfig := nomadStructs.DefaultLogConfig()
+	if resources.DiskMB == 0 && limits.DiskBytes > 0 {
+		// nomad requires room for the task logs besides the build containers
+		resources.DiskMB = int(limits.DiskBytes>>20) + logConfig.MaxFiles*logConfig.MaxFileSizeMB
+	}
+	if resources.CPU == 0 {
+		resources.CPU = defaultNomadCPU
+	}
+	if resources.MemoryMB == 0 {
+		resources.MemoryMB = defaultNomadMemoryMB
+	}
+	if resources.DiskMB == 0 {
+		resources.DiskMB = defaultNomadDiskMB
 	}
 	task := &nomadStructs.Task{
-		Name:      pipeline.Name,
-		Driver:    "gypsy",
-		Config:    config,
-		Resources: resources,
+		Name:   pipeline.Name,
+		Driver: "raw_exec",
+		Config: config,
+		// the token is kept out of the command line
+		Env:         map[string]string{"GYPSY_TOKEN": c.Token},
+		Resources:   resources,
+		KillTimeout: agentKillTimeout,
+		LogConfig:   logConfig,
 	}
 	group := &nomadStructs.TaskGroup{
-		Name:          pipeline.Name,
-		Count:         1,
-		Tasks:         []*nomadStructs.Task{task},
-		RestartPolicy: nomadStructs.NewRestartPolicy("batch"),
+		Name:  pipeline.Name,
+		Count: 1,
+		Tasks: []*nomadStructs.Task{task},
+		// a run is built once, agents report failures to the server. Nomad
+		// only accepts no restart attempts in fail mode.
+		RestartPolicy: &nomadStructs.RestartPolicy{
+			Attempts: 0,
+			Interval: time.Minute,
+			Delay:    15 * time.Second,
+			Mode:     nomadStructs.RestartPolicyModeFail,
+		},
 	}
+	id := NomadJobID(pipeline.Name, runId)
 	job := &nomadStructs.Job{
-		ID:          pipeline.Name,
-		Name:        pipeline.Name,
-		Region:      "global",
-		Priority:    50,
-		Datacenters: []string{"dc1"},
+		ID:          id,
+		Name:        id,
+		Region:      spec.Region,
+		Priority:    spec.Priority,
+		Datacenters: spec.Datacenters,
 		Type:        "batch",
 		TaskGroups:  []*nomadStructs.TaskGroup{group},
+		Meta: map[string]string{
+			"gypsy_pipeline": pipeline.Name,
+			"gypsy_run_id":   strconv.Itoa(runId),
+		},
+	}
+	if job.Region == "" {
+		job.Region = defaultNomadRegion
+	}
+	if job.Priority == 0 {
+		job.Priority = defaultNomadPriority
+	}
+	if len(job.Datacenters) == 0 {
+		job.Datacenters = defaultNomadDatacenters
+	}
+	for _, constraint := range spec.Constraints {
+		job.Constraints = append(job.Constraints, &nomadStructs.Constraint{
+			LTarget: constraint.Attribute,
+			Operand: constraint.Operator,
+			RTarget: constraint.Value,
+		})
 	}
 	if err := job.Validate(); err != nil {
 		log.Errorf("Nomad job validation failed. Error: %s\n", err)
 		return nil, err
 	}
-	apiJob, err := convertJob(job)
+	return &NomadJob{
+		Pipeline: pipeline,
+		Job:      job,
+		Address:  spec.Address,
+		Region:   job.Region,
+	}, nil
+}
+
+// NomadJobID returns the id of the nomad job building a pipeline run
+func NomadJobID(pipeline string, runId int) string {
+	return fmt.Sprintf("gypsy-%s-%d", pipeline, runId)
+}
+
+// nomadClient returns a client for the nomad cluster at address, NOMAD_ADDR
+// or the local agent when empty
+func nomadClient(address, region string) (*nomadApi.Client, error) {
+	config := nomadApi.DefaultConfig()
+	if address != "" {
+		config.Address = address
+	}
+	if region != "" {
+		config.Region = region
+	}
+	client, err := nomadApi.NewClient(config)
 	if err != nil {
-		log.Errorf("Failed to convert nomad job in api call. Error: %s\n", err)
+		log.Errorf("Error creating nomad api client: %s", err)
 		return nil, err
 	}
-	nomadConfig := nomadApi.DefaultConfig()
-	nomadClient, err := nomadApi.NewClient(nomadConfig)
+	return client, nil
+}
+
+// DeregisterNomadJob stops a submitted nomad job and its allocations
+func DeregisterNomadJob(address, region, jobID string) error {
+	nomadClient, err := nomadClient(address, region)
 	if err != nil {
-		log.Errorf("Error creating nomad api client: %s", err)
-		return nil, fmt.Errorf(fmt.Sprintf("Error creating nomad api client: %s", err))
+		return err
 	}
-	evalId, _, nomadErr := nomadClient.Jobs().Register(apiJob, nil)
-	if nomadErr != nil {
-		log.Errorf("Error submitting job: %s", nomadErr)
-		return nil, fmt.Errorf(fmt.Sprintf("Error submitting job: %s", nomadErr))
+	if _, _, err := nomadClient.Jobs().Deregister(jobID, &nomadApi.WriteOptions{Region: region}); err != nil {
+		log.Errorf("Error deregistering nomad job %s: %s", jobID, err)
+		return err
 	}
-	log.Infof("Syccessfullt submitted nomad job. Eval id: %s\n", evalId)
-	return &NomadJob{
-		Pipeline: pipeline,
-		Job:      job,
-	}, nil
+	log.Infof("Deregistered nomad job %s", jobID)
+	return nil
+}
+
+// Nomad allocation client statuses
+const (
+	NomadPending  = "pending"
+	NomadRunning  = "running"
+	NomadComplete = "complete"
+	NomadFailed   = "failed"
+	NomadLost     = "lost"
+)
+
+// NomadJobStatus summarizes the client status of the allocations of a nomad
+// job: running or pending while any allocation is, failed or lost when an
+// allocation ended that way, complete otherwise. Jobs without allocations are
+// pending. The description explains failures, when nomad gives one.
+func NomadJobStatus(address, region, jobID string) (string, string, error) {
+	nomadClient, err := nomadClient(address, region)
+	if err != nil {
+		return "", "", err
+	}
+	allocs, _, err := nomadClient.Jobs().Allocations(jobID, &nomadApi.QueryOptions{Region: region})
+	if err != nil {
+		log.Errorf("Error listing allocations of nomad job %s: %s", jobID, err)
+		return "", "", err
+	}
+	if len(allocs) == 0 {
+		return NomadPending, "", nil
+	}
+	counts := make(map[string]int)
+	var description string
+	for _, alloc := range allocs {
+		counts[alloc.ClientStatus]++
+		if alloc.ClientStatus == NomadFailed || alloc.ClientStatus == NomadLost {
+			description = alloc.ClientDescription
+		}
+	}
+	for _, status := range []string{NomadRunning, NomadPending, NomadFailed, NomadLost} {
+		if counts[status] > 0 {
+			return status, description, nil
+		}
+	}
+	return NomadComplete, "", nil
 }
 
+// Run submits the job to nomad
 func (job *NomadJob) Run() int {
-	log.Infof("Submitting nomad job for pipeline: %s\n", job.Pipeline.Name)
+	log.Infof("Submitting nomad job %s for pipeline: %s\n", job.Job.ID, job.Pipeline.Name)
+	apiJob, err := convertJob(job.Job)
+	if err != nil {
+		log.Errorf("Failed to convert nomad job in api call. Error: %s\n", err)
+		return 1
+	}
+	nomadClient, err := nomadClient(job.Address, job.Region)
+	if err != nil {
+		return 1
+	}
+	evalId, _, err := nomadClient.Jobs().Register(apiJob, &nomadApi.WriteOptions{Region: job.Region})
+	if err != nil {
+		log.Errorf("Error submitting job: %s", err)
+		return 1
+	}
+	log.Infof("Successfully submitted nomad job %s. Eval id: %s\n", job.Job.ID, evalId)
 	return 0
 }
 
+// Status returns the summarized status of the job's allocations
+func (job *NomadJob) Status() (string, string, error) {
+	return NomadJobStatus(job.Address, job.Region, job.Job.ID)
+}
+
 func convertJob(in *nomadStructs.Job) (*nomadApi.Job, error) {
 	gob.Register([]map[string]interface{}{})
 	gob.Register([]interface{}{})
+	gob.Register([]string{})
 	var apiJob *nomadApi.Job
 	buf := new(bytes.Buffer)
 	if err := gob.NewEncoder(buf).Encode(in); err != nil {
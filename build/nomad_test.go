@@ -0,0 +1,99 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	nomadStructs "github.com/hashicorp/nomad/nomad/structs"
+	"github.com/ranjib/gypsy/structs"
+	"reflect"
+	"testing"
+)
+
+func TestCreateNomadJob(t *testing.T) {
+	pipeline := &structs.Pipeline{
+		Name:      "gypsy",
+		Container: "ubuntu",
+		Resources: structs.Resources{Memory: "512M", CPUShares: 512},
+		Nomad: structs.NomadConfig{
+			Datacenters: []string{"dc2"},
+			Constraints: []structs.NomadConstraint{{Attribute: "${attr.kernel.name}", Operator: "=", Value: "linux"}},
+		},
+	}
+	b := NewBuilder("http://gypsy:5678", "gypsy", 7)
+	b.Token = "secret-token"
+	nomadJob, err := b.CreateNomadJob(pipeline, 7)
+	if err != nil {
+		t.Fatalf("CreateNomadJob failed: %v", err)
+	}
+	job := nomadJob.Job
+	if err := job.Validate(); err != nil {
+		t.Fatalf("nomad job is invalid: %v", err)
+	}
+	if job.ID != "gypsy-gypsy-7" || job.Region != defaultNomadRegion || job.Priority != defaultNomadPriority {
+		t.Errorf("unexpected job id, region or priority: %s %s %d", job.ID, job.Region, job.Priority)
+	}
+	if !reflect.DeepEqual(job.Datacenters, []string{"dc2"}) {
+		t.Errorf("unexpected datacenters %v", job.Datacenters)
+	}
+	if len(job.Constraints) != 1 || job.Constraints[0].RTarget != "linux" {
+		t.Errorf("unexpected constraints %v", job.Constraints)
+	}
+	group := job.TaskGroups[0]
+	policy := group.RestartPolicy
+	if policy.Attempts != 0 || policy.Mode != nomadStructs.RestartPolicyModeFail {
+		t.Errorf("agents must not be restarted, got %+v", policy)
+	}
+	task := group.Tasks[0]
+	args := []string{"agent", "-container", "ubuntu", "-pipeline", "gypsy", "-run_id", "7", "-address", "http://gypsy:5678"}
+	if task.Driver != "raw_exec" || task.Config["command"] != defaultAgentCommand || !reflect.DeepEqual(task.Config["args"], args) {
+		t.Errorf("unexpected task driver or config: %s %v", task.Driver, task.Config)
+	}
+	if task.Env["GYPSY_TOKEN"] != "secret-token" {
+		t.Errorf("agent token not passed in the environment")
+	}
+	if task.Resources.CPU != 512 || task.Resources.MemoryMB != 512 {
+		t.Errorf("pipeline resources not reserved: %+v", task.Resources)
+	}
+}
+
+func TestCreateNomadJobDisk(t *testing.T) {
+	logs := nomadStructs.DefaultLogConfig()
+	logMB := logs.MaxFiles * logs.MaxFileSizeMB
+	tests := []struct {
+		resources structs.Resources
+		nomad     structs.NomadResources
+		diskMB    int
+	}{
+		{structs.Resources{}, structs.NomadResources{}, defaultNomadDiskMB},
+		{structs.Resources{Disk: "1G"}, structs.NomadResources{}, 1024 + logMB},
+		{structs.Resources{Disk: "1G"}, structs.NomadResources{DiskMB: 2048}, 2048},
+	}
+	for _, test := range tests {
+		pipeline := &structs.Pipeline{Name: "gypsy", Container: "ubuntu", Resources: test.resources}
+		pipeline.Nomad.Resources = test.nomad
+		nomadJob, err := NewBuilder("http://gypsy:5678", "gypsy", 1).CreateNomadJob(pipeline, 1)
+		if err != nil {
+			t.Errorf("%+v: CreateNomadJob failed: %v", test.resources, err)
+			continue
+		}
+		task := nomadJob.Job.TaskGroups[0].Tasks[0]
+		if task.Resources.DiskMB != test.diskMB {
+			t.Errorf("%+v: expected %d MB of disk, got %d", test.resources, test.diskMB, task.Resources.DiskMB)
+		}
+		if task.LogConfig == nil {
+			t.Errorf("%+v: task without log config", test.resources)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"github.com/ranjib/gypsy/build"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+type AgentCommand struct {
+	Meta
+}
+
+func (c *AgentCommand) Help() string {
+	helpString := `
+	Usage: gypsy agent -pipeline PIPELINE_NAME -run_id RUN_ID [-container CONTAINER]
+
+	Builds a pipeline run on this host and reports the result to the server.
+	Nomad jobs scheduled by the server run it with the raw_exec driver. The
+	exit code is 0 when the build succeeded, 1 when it failed, was cancelled
+	or timed out, and 2 when the run could not be built.
+	SIGINT and SIGTERM cancel the build.
+
+	General Options:
+	` + generalOptionsUsage()
+	return strings.TrimSpace(helpString)
+}
+
+func (c *AgentCommand) Synopsis() string {
+	return "Runs a build of a pipeline run"
+}
+
+func (c *AgentCommand) Run(args []string) int {
+	var pipelineName string
+	var runId int
+	var container string
+	flags := c.Meta.FlagSet("agent", FlagSetClient)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.StringVar(&pipelineName, "pipeline", "", "Name of target pipeline")
+	flags.IntVar(&runId, "run_id", 0, "Run ID")
+	flags.StringVar(&container, "container", "", "Container to clone instead of the pipeline container")
+	if err := flags.Parse(args); err != nil {
+		log.Errorf("Failed to parse flags: %v", err)
+		return build.ExitErrored
+	}
+	var logOutput io.Writer
+	if c.Meta.logOutput != "" {
+		fi, err := os.OpenFile(c.Meta.logOutput, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Errorf("Failed to open log output file '%s'. Error: %s\n", c.Meta.logOutput, err)
+			return build.ExitErrored
+		}
+		defer fi.Close()
+		logOutput = fi
+	} else {
+		logOutput = os.Stdout
+	}
+	util.ConfigureLogging(c.Meta.logLevel, c.Meta.logFormat, logOutput)
+	if pipelineName == "" {
+		log.Errorf("Must provide a valid pipeline name")
+		return build.ExitErrored
+	}
+	if runId == 0 {
+		log.Errorf("Must provide a valid run id")
+		return build.ExitErrored
+	}
+	config := c.Meta.clientConfig()
+	b := build.NewBuilder(strings.TrimSuffix(config.Address, "/"), pipelineName, runId)
+	b.Token = config.Token
+	if err := b.ConfigureTLS(config.CACert, config.ClientCert, config.ClientKey); err != nil {
+		return build.ExitErrored
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if sig, ok := <-sigs; ok {
+			log.Infof("Received %s, cancelling build", sig)
+			b.Cancel()
+		}
+	}()
+	exitCode := b.RunAgent(container)
+	log.Infof("Run %d of pipeline %s finished with status %s", runId, pipelineName, b.Run.Status)
+	return exitCode
+}
@@ -30,7 +30,7 @@ type BuildCommand struct {
 
 func (c *BuildCommand) Help() string {
 	helpText := `
-   Usage: gypsy build PIPELINE_NAME -run_id RUN_ID
+   Usage: gypsy build -pipeline PIPELINE_NAME -run_id RUN_ID [-address URL]
 
 	 General Options:
 	` + generalOptionsUsage()
@@ -73,5 +73,11 @@ func (c *BuildCommand) Run(args []string) int {
 		log.Errorf("Must provide a valid run id")
 		return 1
 	}
-	return build.BuildPipeline(pipelineName, runId)
+	config := c.Meta.clientConfig()
+	b := build.NewBuilder(strings.TrimSuffix(config.Address, "/"), pipelineName, runId)
+	b.Token = config.Token
+	if err := b.ConfigureTLS(config.CACert, config.ClientCert, config.ClientKey); err != nil {
+		return 1
+	}
+	return b.Build()
 }
@@ -15,10 +15,9 @@
 package command
 
 import (
-	"github.com/ranjib/gypsy/structs"
 	"github.com/ranjib/gypsy/util"
+	"github.com/ranjib/gypsy/validation"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
 	"os"
@@ -79,13 +78,19 @@ func (c *CreatePipelineCommand) Run(args []string) int {
 		log.Errorf("Failed to read file %s . Error: %s\n", file, err)
 		return -1
 	}
-	var pipeline structs.Pipeline
-	if err := yaml.Unmarshal(content, &pipeline); err != nil {
-		log.Errorf("Failed to unmarshal pipeline : %v", err)
+	result := validation.Document(content)
+	for _, warning := range result.Warnings {
+		c.Ui.Warn(file + ": warning: " + warning.String())
+	}
+	if result.Errors != nil {
+		for _, err := range result.Errors {
+			c.Ui.Error(file + ": error: " + err.String())
+		}
 		return -1
 	}
+	pipeline := result.Pipeline
 
-	if err := client.CreatePipeline(&pipeline); err != nil {
+	if err := client.CreatePipeline(pipeline); err != nil {
 		log.Errorf("Failed to create pipeline. Error: %s\n", err)
 		return -1
 	}
@@ -0,0 +1,89 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type LogsCommand struct {
+	Meta
+}
+
+func (c *LogsCommand) Help() string {
+	helpString := `
+	Usage: gypsy logs [-f] <pipeline name> <run id>
+
+	Logs Options:
+	-f
+		Follow the output until the run finishes
+
+	General Options:
+	` + generalOptionsUsage()
+	return strings.TrimSpace(helpString)
+}
+
+func (c *LogsCommand) Synopsis() string {
+	return "Show the output of a pipeline run"
+}
+
+func (c *LogsCommand) Run(args []string) int {
+	var follow bool
+	flags := c.Meta.FlagSet("logs", FlagSetClient)
+	flags.BoolVar(&follow, "f", false, "Follow the output until the run finishes")
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		log.Errorf("Failed to parse cli arguments. Error: %s\n", err)
+		return 1
+	}
+	var logOutput io.Writer
+	if c.Meta.logOutput != "" {
+		fi, err := os.OpenFile(c.Meta.logOutput, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Errorf("Failed to open log output file '%s'. Error: %s\n", c.Meta.logOutput, err)
+			return -1
+		}
+		defer fi.Close()
+		logOutput = fi
+	} else {
+		logOutput = os.Stderr
+	}
+	util.ConfigureLogging(c.Meta.logLevel, c.Meta.logFormat, logOutput)
+	args = flags.Args()
+	if len(args) != 2 {
+		c.Ui.Error(c.Help())
+		return -1
+	}
+	runId, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Errorf("Invalid run id '%s'. Error: %s\n", args[1], err)
+		return -1
+	}
+	client, err := c.Meta.Client()
+	if err != nil {
+		log.Errorf("Failed to create api client. Error:%s\n", err)
+		return -1
+	}
+	if err := client.StreamLog(args[0], runId, follow, os.Stdout); err != nil {
+		log.Errorf("Failed to obtain run log. Error:%s\n", err)
+		return -1
+	}
+	return 0
+}
@@ -15,8 +15,10 @@ package command
 
 import (
 	"flag"
+	"fmt"
 	"github.com/mitchellh/cli"
 	"github.com/ranjib/gypsy/api"
+	"os"
 	"strings"
 )
 
@@ -29,11 +31,15 @@ const (
 )
 
 type Meta struct {
-	Ui        cli.Ui
-	address   string
-	logLevel  string
-	logFormat string
-	logOutput string
+	Ui         cli.Ui
+	address    string
+	token      string
+	caCert     string
+	clientCert string
+	clientKey  string
+	logLevel   string
+	logFormat  string
+	logOutput  string
 }
 
 func (m *Meta) FlagSet(n string, fs FlagSetFlags) *flag.FlagSet {
@@ -44,22 +50,80 @@ func (m *Meta) FlagSet(n string, fs FlagSetFlags) *flag.FlagSet {
 		flags.StringVar(&m.logOutput, "logoutput", "", "-logoutput <file>")
 	}
 	if fs&FlagSetClient != 0 {
-		flags.StringVar(&m.address, "address", "http://localhost:5678", "-address <gypsy server>")
+		flags.StringVar(&m.address, "address", "", "-address <gypsy server>")
+		flags.StringVar(&m.token, "token", "", "-token <api token>")
+		flags.StringVar(&m.caCert, "ca-cert", "", "-ca-cert <file>")
+		flags.StringVar(&m.clientCert, "client-cert", "", "-client-cert <file>")
+		flags.StringVar(&m.clientKey, "client-key", "", "-client-key <file>")
 	}
 	return flags
 }
 
 func (m *Meta) Client() (*api.Client, error) {
+	return api.NewClient(m.clientConfig())
+}
+
+// clientConfig returns the api configuration from flags, falling back to the
+// GYPSY_* environment variables
+func (m *Meta) clientConfig() *api.Config {
 	config := api.DefaultConfig()
-	config.Address = m.address
-	return api.NewClient(config)
+	if m.address != "" {
+		config.Address = m.address
+	}
+	config.Token = m.apiToken()
+	if m.caCert != "" {
+		config.CACert = m.caCert
+	}
+	if m.clientCert != "" {
+		config.ClientCert = m.clientCert
+	}
+	if m.clientKey != "" {
+		config.ClientKey = m.clientKey
+	}
+	return config
+}
+
+// apiToken returns the -token flag, or the GYPSY_TOKEN environment variable
+func (m *Meta) apiToken() string {
+	if m.token != "" {
+		return m.token
+	}
+	return os.Getenv("GYPSY_TOKEN")
+}
+
+// kvFlag collects repeated -flag key=value arguments
+type kvFlag map[string]string
+
+func (kv kvFlag) String() string {
+	pairs := []string{}
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (kv kvFlag) Set(value string) error {
+	fields := strings.SplitN(value, "=", 2)
+	if len(fields) != 2 || fields[0] == "" {
+		return fmt.Errorf("Expected key=value, got '%s'", value)
+	}
+	kv[fields[0]] = fields[1]
+	return nil
 }
 
 func generalOptionsUsage() string {
 	helpText := `
 	-address=<addr>
-		Address of gypsy server
-		Default = http://localhost:5678
+		Address of gypsy server, defaults to the GYPSY_ADDR environment
+		variable or http://localhost:5678
+	-token=<token>
+		API token, defaults to the GYPSY_TOKEN environment variable
+	-ca-cert=<file>
+		CA certificate verifying the server, defaults to GYPSY_CACERT
+	-client-cert=<file>
+		Client certificate, defaults to GYPSY_CLIENT_CERT
+	-client-key=<file>
+		Client certificate key, defaults to GYPSY_CLIENT_KEY
 	-loglevel=<level>
 		Set log level (can be debug, info, warn, error, fatal or panic)
 		Default = info
@@ -0,0 +1,134 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build lxc
+// +build lxc
+
+package command
+
+import (
+	"encoding/json"
+	"github.com/ranjib/gypsy/container"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type PoolCommand struct {
+	Meta
+}
+
+func (c *PoolCommand) Help() string {
+	helpString := `
+	Usage: gypsy pool [options] CONTAINER...
+
+	Keeps started clones of the given containers ready for the builds of
+	this host. Build jobs of the lxc executor take a ready container
+	instead of cloning and starting one, and the pool is refilled in the
+	background. Pool statistics are served as json on the stats address
+	(GET /stats). SIGINT and SIGTERM destroy the ready containers and stop
+	the pool.
+
+	Options:
+		-size=2             Ready containers kept per container
+		-clone=MODE         Clone mode of the pooled containers (default: copy)
+		-dir=PATH           Directory registering the ready containers
+		-interval=5s        How often the pool is refilled
+		-stats-addr=ADDR    Address serving the pool statistics (default: 127.0.0.1:5679)
+
+	General Options:
+	` + generalOptionsUsage()
+	return strings.TrimSpace(helpString)
+}
+
+func (c *PoolCommand) Synopsis() string {
+	return "Keeps warm containers ready for builds"
+}
+
+func (c *PoolCommand) Run(args []string) int {
+	var size int
+	var clone, dir, statsAddr string
+	var interval time.Duration
+	flags := c.Meta.FlagSet("pool", FlagSetLog)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.IntVar(&size, "size", 2, "Ready containers kept per container")
+	flags.StringVar(&clone, "clone", "", "Clone mode of the pooled containers")
+	flags.StringVar(&dir, "dir", container.DefaultPoolDir, "Directory registering the ready containers")
+	flags.DurationVar(&interval, "interval", 5*time.Second, "How often the pool is refilled")
+	flags.StringVar(&statsAddr, "stats-addr", "127.0.0.1:5679", "Address serving the pool statistics")
+	if err := flags.Parse(args); err != nil {
+		log.Errorf("Failed to parse cli arguments. Error: %s\n", err)
+		return 1
+	}
+	var logOutput io.Writer
+	if c.Meta.logOutput != "" {
+		fi, err := os.OpenFile(c.Meta.logOutput, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Errorf("Failed to open log output file '%s'. Error: %s\n", c.Meta.logOutput, err)
+			return -1
+		}
+		defer fi.Close()
+		logOutput = fi
+	} else {
+		logOutput = os.Stdout
+	}
+	util.ConfigureLogging(c.Meta.logLevel, c.Meta.logFormat, logOutput)
+	bases := flags.Args()
+	if len(bases) == 0 {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	if size < 1 || interval <= 0 {
+		log.Errorf("Pool size and refill interval must be positive")
+		return 1
+	}
+	pool, err := container.NewPool(dir, size, clone, bases)
+	if err != nil {
+		return 1
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(pool.Stats()); err != nil {
+			log.Errorf("Failed to encode pool stats. Error: %v", err)
+		}
+	})
+	statsServer := &http.Server{Addr: statsAddr, Handler: mux}
+	go func() {
+		if err := statsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Failed to serve pool stats on %s. Error: %v", statsAddr, err)
+		}
+	}()
+	defer statsServer.Close()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		pool.Run(interval, stop)
+		close(done)
+	}()
+	log.Infof("Keeping %d warm containers of %s", size, strings.Join(bases, ", "))
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	log.Infof("Received %s, draining the pool", sig)
+	close(stop)
+	<-done
+	return 0
+}
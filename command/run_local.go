@@ -0,0 +1,144 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/build"
+	"github.com/ranjib/gypsy/util"
+	"github.com/ranjib/gypsy/validation"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type RunLocalCommand struct {
+	Meta
+}
+
+func (c *RunLocalCommand) Help() string {
+	helpString := `
+	Usage: gypsy run-local [options] [gypsy.yml]
+
+	Builds a pipeline on the local LXC host without a server. Materials are
+	checked out from the working directory and artifacts are copied to a
+	local directory.
+
+	Options:
+		-dir=.              Directory used as the content of every material
+		-dest=PATH          Also check out the directory at PATH in the containers
+		-artifacts=DIR      Directory artifacts are copied to (default: artifacts)
+		-executor=NAME      Run the jobs with this executor (lxc or local)
+		-param name=value   Pipeline parameter override
+		-env name=value     Environment variable
+
+	General Options:
+	` + generalOptionsUsage()
+	return strings.TrimSpace(helpString)
+}
+
+func (c *RunLocalCommand) Synopsis() string {
+	return "Build a pipeline locally without a server"
+}
+
+func (c *RunLocalCommand) Run(args []string) int {
+	var workDir, dest, artifactDir, executorName string
+	parameters := make(map[string]string)
+	env := make(map[string]string)
+	flags := c.Meta.FlagSet("run-local", FlagSetLog)
+	flags.StringVar(&workDir, "dir", ".", "Directory used as the content of every material")
+	flags.StringVar(&dest, "dest", "", "Also check out the directory at this path in the containers")
+	flags.StringVar(&artifactDir, "artifacts", "artifacts", "Directory artifacts are copied to")
+	flags.StringVar(&executorName, "executor", "", "Executor replacing the pipeline executor")
+	flags.Var(kvFlag(parameters), "param", "Pipeline parameter override (name=value)")
+	flags.Var(kvFlag(env), "env", "Environment variable (name=value)")
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		log.Errorf("Failed to parse cli arguments. Error: %s\n", err)
+		return 1
+	}
+	var logOutput io.Writer
+	if c.Meta.logOutput != "" {
+		fi, err := os.OpenFile(c.Meta.logOutput, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Errorf("Failed to open log output file '%s'. Error: %s\n", c.Meta.logOutput, err)
+			return -1
+		}
+		defer fi.Close()
+		logOutput = fi
+	} else {
+		logOutput = os.Stderr
+	}
+	util.ConfigureLogging(c.Meta.logLevel, c.Meta.logFormat, logOutput)
+	file := "gypsy.yml"
+	args = flags.Args()
+	if len(args) > 0 {
+		file = args[0]
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Errorf("Failed to read file %s . Error: %s\n", file, err)
+		return -1
+	}
+	result := validation.Document(content)
+	if executorName != "" && result.Pipeline != nil {
+		// validate against the executor actually used
+		result.Pipeline.Executor = executorName
+		result.Errors = nil
+		for _, err := range validation.Pipeline(result.Pipeline) {
+			result.Add(err.Field, "%s", err.Message)
+		}
+	}
+	for _, warning := range result.Warnings {
+		c.Ui.Warn(describeProblem(file, "warning", warning))
+	}
+	if result.Errors != nil {
+		for _, err := range result.Errors {
+			c.Ui.Error(describeProblem(file, "error", err))
+		}
+		return -1
+	}
+	pipeline := result.Pipeline
+	workDir, err = filepath.Abs(workDir)
+	if err != nil {
+		log.Errorf("Failed to resolve directory %s. Error: %s\n", workDir, err)
+		return -1
+	}
+	build.LocalMaterials(pipeline, workDir, dest)
+
+	c.Ui.Output("Building pipeline " + pipeline.Name + " locally")
+	b := build.NewBuilder("", pipeline.Name, 0)
+	b.ArtifactDir = artifactDir
+	b.Output = os.Stdout
+	b.Run.Parameters = parameters
+	b.Run.Env = env
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if _, ok := <-sigs; ok {
+			log.Infof("Received signal, cancelling build")
+			b.Cancel()
+		}
+	}()
+	status := b.LocalBuild(pipeline)
+	c.Ui.Output(fmt.Sprintf("Pipeline %s %s", pipeline.Name, b.Run.Status))
+	return status
+}
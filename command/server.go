@@ -82,7 +82,10 @@ func (c *ServerCommand) Run(args []string) int {
 	if config == nil {
 		return 1
 	}
-	c.setup(config)
+	if err := c.setup(config); err != nil {
+		log.Errorf("Failed to set up the server. Error: %v", err)
+		return 1
+	}
 	defer func() {
 		if c.httpServer != nil {
 			c.httpServer.Shutdown()
@@ -103,7 +106,7 @@ func (c *ServerCommand) setup(config *server.Config) error {
 		log.Errorln(err)
 		return err
 	}
-	db.Update(func(tx *bolt.Tx) error {
+	err = db.Update(func(tx *bolt.Tx) error {
 		if _, err := tx.CreateBucketIfNotExists([]byte("pipelines")); err != nil {
 			log.Errorln(err)
 			return err
@@ -120,15 +123,59 @@ func (c *ServerCommand) setup(config *server.Config) error {
 			log.Errorln(err)
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("queue")); err != nil {
+			log.Errorln(err)
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("logs")); err != nil {
+			log.Errorln(err)
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("secrets")); err != nil {
+			log.Errorln(err)
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("tokens")); err != nil {
+			log.Errorln(err)
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("pipelineRevisions")); err != nil {
+			log.Errorln(err)
+			return err
+		}
 		return nil
 	})
-	s, err := server.NewHttpServer(config.BindAddr, config.ArtifactDir, db)
+	if err != nil {
+		return err
+	}
+	if err := server.BootstrapToken(db, filepath.Join(config.DataDir, "admin.token")); err != nil {
+		log.Errorln(err)
+		return err
+	}
+	// configuration errors must surface before the queue starts scheduling
+	// builds
+	secrets, err := server.NewSecretStore(config.SecretKey)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	tlsConfig, err := config.TLS()
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	queue, err := server.NewQueue(config.MaxConcurrentBuilds, config.ServerURL(), config.BaseEnv, config.Clone, db)
+	if err != nil {
+		log.Errorln(err)
+		return err
+	}
+	s, err := server.NewHttpServer(config.BindAddr, config.ArtifactDir, db, queue, secrets, tlsConfig)
 	if err != nil {
 		log.Errorln(err)
 		return err
 	}
 	c.httpServer = s
-	c.poller = server.NewPoller(config.PollingFrequency, db)
+	c.poller = server.NewPoller(config.PollingFrequency, filepath.Join(config.DataDir, "materials"), db, queue)
 	return nil
 }
 
@@ -0,0 +1,122 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type TriggerCommand struct {
+	Meta
+}
+
+func (c *TriggerCommand) Help() string {
+	helpString := `
+	Usage: gypsy trigger [options] <pipeline name>
+
+	Trigger Options:
+	-wait
+		Wait for the run to finish, exit code reflects the build result
+	-branch=<branch>
+		Branch to build
+	-revision=<revision>
+		Revision of the first pipeline material to build
+	-param <name>=<value>
+		Override a pipeline parameter (can be repeated)
+	-env <name>=<value>
+		Set an environment variable for the build (can be repeated)
+
+	General Options:
+	` + generalOptionsUsage()
+	return strings.TrimSpace(helpString)
+}
+
+func (c *TriggerCommand) Synopsis() string {
+	return "Trigger a pipeline run"
+}
+
+func (c *TriggerCommand) Run(args []string) int {
+	var wait bool
+	request := &structs.RunRequest{
+		Parameters: make(map[string]string),
+		Env:        make(map[string]string),
+	}
+	flags := c.Meta.FlagSet("trigger", FlagSetClient)
+	flags.BoolVar(&wait, "wait", false, "Wait for the run to finish")
+	flags.StringVar(&request.Branch, "branch", "", "Branch to build")
+	flags.StringVar(&request.Revision, "revision", "", "Revision to build")
+	flags.Var(kvFlag(request.Parameters), "param", "Pipeline parameter override (name=value)")
+	flags.Var(kvFlag(request.Env), "env", "Environment variable (name=value)")
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		log.Errorf("Failed to parse cli arguments. Error: %s\n", err)
+		return 1
+	}
+	var logOutput io.Writer
+	if c.Meta.logOutput != "" {
+		fi, err := os.OpenFile(c.Meta.logOutput, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Errorf("Failed to open log output file '%s'. Error: %s\n", c.Meta.logOutput, err)
+			return -1
+		}
+		defer fi.Close()
+		logOutput = fi
+	} else {
+		logOutput = os.Stdout
+	}
+	util.ConfigureLogging(c.Meta.logLevel, c.Meta.logFormat, logOutput)
+	args = flags.Args()
+	if len(args) != 1 {
+		c.Ui.Error(c.Help())
+		return -1
+	}
+	client, err := c.Meta.Client()
+	if err != nil {
+		log.Errorf("Failed to create api client. Error:%s\n", err)
+		return -1
+	}
+	id, err := client.TriggerRun(args[0], request)
+	if err != nil {
+		log.Errorf("Failed to trigger run. Error:%s\n", err)
+		return -1
+	}
+	c.Ui.Output(fmt.Sprintf("Triggered run %d of pipeline %s", id, args[0]))
+	if !wait {
+		return 0
+	}
+	for {
+		run, err := client.GetRun(args[0], id)
+		if err != nil {
+			log.Errorf("Failed to obtain run details. Error:%s\n", err)
+			return -1
+		}
+		if run.Status.Finished() {
+			if run.Status == structs.RunSucceeded {
+				c.Ui.Output(fmt.Sprintf("Run %d of pipeline %s succeeded", id, args[0]))
+				return 0
+			}
+			c.Ui.Error(fmt.Sprintf("Run %d of pipeline %s %s", id, args[0], run.Status))
+			return 1
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
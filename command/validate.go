@@ -0,0 +1,144 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/util"
+	"github.com/ranjib/gypsy/validation"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type ValidateCommand struct {
+	Meta
+}
+
+func (c *ValidateCommand) Help() string {
+	helpString := `
+	Usage: gypsy validate [-check-containers=false] FILE...
+
+	Validates pipeline yaml files. Errors and unknown fields are reported
+	with their line and column, and containers used by the pipelines are
+	checked to exist on this host.
+
+	General Options:
+	` + generalOptionsUsage()
+	return strings.TrimSpace(helpString)
+}
+
+func (c *ValidateCommand) Synopsis() string {
+	return "Validate pipeline yaml files"
+}
+
+func (c *ValidateCommand) Run(args []string) int {
+	var checkContainers bool
+	flags := c.Meta.FlagSet("validate", FlagSetLog)
+	flags.BoolVar(&checkContainers, "check-containers", true, "Check that referenced containers exist locally")
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	if err := flags.Parse(args); err != nil {
+		log.Errorf("Failed to parse cli arguments. Error: %s\n", err)
+		return 1
+	}
+	var logOutput io.Writer
+	if c.Meta.logOutput != "" {
+		fi, err := os.OpenFile(c.Meta.logOutput, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Errorf("Failed to open log output file '%s'. Error: %s\n", c.Meta.logOutput, err)
+			return -1
+		}
+		defer fi.Close()
+		logOutput = fi
+	} else {
+		logOutput = os.Stderr
+	}
+	util.ConfigureLogging(c.Meta.logLevel, c.Meta.logFormat, logOutput)
+	files := flags.Args()
+	if len(files) == 0 {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	status := 0
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("%s: error: %s", file, err))
+			status = 1
+			continue
+		}
+		result := validation.Document(content)
+		if checkContainers && result.Pipeline != nil {
+			checkPipelineContainers(result)
+		}
+		for _, warning := range result.Warnings {
+			c.Ui.Warn(describeProblem(file, "warning", warning))
+		}
+		for _, err := range result.Errors {
+			c.Ui.Error(describeProblem(file, "error", err))
+		}
+		if result.Errors != nil {
+			status = 1
+		} else {
+			c.Ui.Output(file + ": ok")
+		}
+	}
+	return status
+}
+
+// describeProblem formats a validation problem like compilers do,
+// i.e. FILE:LINE:COLUMN: error: field: message
+func describeProblem(file, kind string, e validation.Error) string {
+	position := file
+	switch {
+	case e.Column > 0:
+		position = fmt.Sprintf("%s:%d:%d", file, e.Line, e.Column)
+	case e.Line > 0:
+		position = fmt.Sprintf("%s:%d", file, e.Line)
+	}
+	message := e.Message
+	if e.Field != "" {
+		message = e.Field + ": " + message
+	}
+	return fmt.Sprintf("%s: %s: %s", position, kind, message)
+}
+
+// checkPipelineContainers reports containers used by the pipeline or its
+// jobs that its executor can't find on this host. Containers of local builds
+// are root filesystem directories.
+func checkPipelineContainers(result *validation.Result) {
+	pipeline := result.Pipeline
+	exec, err := executor.Get(pipeline.Executor)
+	if err != nil {
+		log.Warnf("Not checking containers of pipeline %s. Error: %v", pipeline.Name, err)
+		return
+	}
+	if pipeline.Container != "" && !exec.Exists(pipeline.Container) {
+		result.Add("container", "container '%s' does not exist", pipeline.Container)
+	}
+	for i, stage := range pipeline.Stages {
+		for j, job := range stage.Jobs {
+			if job.Container == "" || job.Container == pipeline.Container {
+				continue
+			}
+			if !exec.Exists(job.Container) {
+				result.Add(fmt.Sprintf("stages[%d].jobs[%d].container", i, j), "container '%s' does not exist", job.Container)
+			}
+		}
+	}
+}
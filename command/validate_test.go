@@ -0,0 +1,40 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package command
+
+import (
+	"github.com/ranjib/gypsy/validation"
+	"testing"
+)
+
+func TestDescribeProblem(t *testing.T) {
+	tests := []struct {
+		err         validation.Error
+		description string
+	}{
+		{validation.Error{Field: "container", Message: "is required", Line: 3, Column: 1}, "gypsy.yml:3:1: error: container: is required"},
+		{validation.Error{Message: "did not find expected node content", Line: 2}, "gypsy.yml:2: error: did not find expected node content"},
+		{validation.Error{Field: "name", Message: "is required"}, "gypsy.yml: error: name: is required"},
+	}
+	for _, test := range tests {
+		if d := describeProblem("gypsy.yml", "error", test.err); d != test.description {
+			t.Errorf("expected %q, got %q", test.description, d)
+		}
+	}
+	warning := validation.Error{Field: "colour", Message: "unknown field 'colour'", Line: 2, Column: 1}
+	if d := describeProblem("-", "warning", warning); d != "-:2:1: warning: colour: unknown field 'colour'" {
+		t.Errorf("unexpected warning description %q", d)
+	}
+}
@@ -6,6 +6,12 @@ import (
 	"os"
 )
 
+// lxcCommands returns the commands needing LXC, set by binaries built with
+// the lxc build tag
+var lxcCommands = func(meta command.Meta) map[string]cli.CommandFactory {
+	return nil
+}
+
 // Commands register all gypsy related commands
 func Commands() map[string]cli.CommandFactory {
 	meta := command.Meta{
@@ -14,7 +20,7 @@ func Commands() map[string]cli.CommandFactory {
 			ErrorWriter: os.Stderr,
 		},
 	}
-	return map[string]cli.CommandFactory{
+	commands := map[string]cli.CommandFactory{
 		"build": func() (cli.Command, error) {
 			return &command.BuildCommand{
 				Meta: meta,
@@ -25,8 +31,23 @@ func Commands() map[string]cli.CommandFactory {
 				Meta: meta,
 			}, nil
 		},
-		"dockerfile": func() (cli.Command, error) {
-			return &command.DockerfileCommand{
+		"agent": func() (cli.Command, error) {
+			return &command.AgentCommand{
+				Meta: meta,
+			}, nil
+		},
+		"cancel": func() (cli.Command, error) {
+			return &command.CancelCommand{
+				Meta: meta,
+			}, nil
+		},
+		"validate": func() (cli.Command, error) {
+			return &command.ValidateCommand{
+				Meta: meta,
+			}, nil
+		},
+		"run-local": func() (cli.Command, error) {
+			return &command.RunLocalCommand{
 				Meta: meta,
 			}, nil
 		},
@@ -50,6 +71,16 @@ func Commands() map[string]cli.CommandFactory {
 				Meta: meta,
 			}, nil
 		},
+		"logs": func() (cli.Command, error) {
+			return &command.LogsCommand{
+				Meta: meta,
+			}, nil
+		},
+		"trigger": func() (cli.Command, error) {
+			return &command.TriggerCommand{
+				Meta: meta,
+			}, nil
+		},
 		"version": func() (cli.Command, error) {
 			return &command.VersionCommand{
 				Revision:         GitCommit,
@@ -59,4 +90,8 @@ func Commands() map[string]cli.CommandFactory {
 			}, nil
 		},
 	}
+	for name, factory := range lxcCommands(meta) {
+		commands[name] = factory
+	}
+	return commands
 }
@@ -0,0 +1,41 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build lxc
+// +build lxc
+
+package main
+
+import (
+	"github.com/mitchellh/cli"
+	"github.com/ranjib/gypsy/command"
+	_ "github.com/ranjib/gypsy/container"
+)
+
+func init() {
+	lxcCommands = func(meta command.Meta) map[string]cli.CommandFactory {
+		return map[string]cli.CommandFactory{
+			"dockerfile": func() (cli.Command, error) {
+				return &command.DockerfileCommand{
+					Meta: meta,
+				}, nil
+			},
+			"pool": func() (cli.Command, error) {
+				return &command.PoolCommand{
+					Meta: meta,
+				}, nil
+			},
+		}
+	}
+}
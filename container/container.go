@@ -0,0 +1,182 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build lxc
+// +build lxc
+
+// Provides LXC containers to build jobs: container helpers, the lxc build
+// executor and the warm container pool. The package links liblxc and is only
+// built with the lxc build tag.
+package container
+
+import (
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/lxc/go-lxc.v2"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PostFile uploads a file of a running container as an artifact
+func PostFile(client *http.Client, ct *lxc.Container, src, url, token string) error {
+	uuid, err := util.UUID()
+	if err != nil {
+		log.Errorf("Failed to generate uuid for temporary file name. Error: %v", err)
+		return err
+	}
+	dst := filepath.Join(os.TempDir(), uuid)
+	if err := CopyFile(ct, src, dst); err != nil {
+		return err
+	}
+	defer os.Remove(dst)
+	return util.PostFile(client, dst, url, token)
+}
+
+// CopyFile copies a file from inside a running container to dest
+// on the host
+func CopyFile(ct *lxc.Container, src, dest string) error {
+	uuid, err := util.UUID()
+	if err != nil {
+		log.Errorf("Failed to generate uuid for temporary file name. Error: %v", err)
+		return err
+	}
+	tmp := filepath.Join("/tmp", uuid)
+	cmd := []string{"cp", src, tmp}
+	if _, err := ct.RunCommandStatus(cmd, lxc.DefaultAttachOptions); err != nil {
+		log.Errorf("Failed to execute: '%s' inside container '%s'", strings.Join(cmd, " "), ct.Name())
+		return err
+	}
+	in, err := os.Open(Path(ct, tmp))
+	if err != nil {
+		log.Errorf("Failed to open file %s. Error: %v", tmp, err)
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		log.Errorf("Failed to create file %s. Error: %v", dest, err)
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		log.Errorf("Failed to copy file. Error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CloneAndStart clones a container and starts the clone
+func CloneAndStart(original, cloned string) (*lxc.Container, error) {
+	ct, err := Clone(original, cloned, lxc.CloneOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := Start(ct); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// Clone clones a container without starting the clone. Snapshot
+// clones fall back to a full copy when the backing store of the original
+// container can not be snapshotted.
+func Clone(original, cloned string, options lxc.CloneOptions) (*lxc.Container, error) {
+	orig, err := lxc.NewContainer(original)
+	if err != nil {
+		log.Errorf("Failed to initialize container object. Error: %v", err)
+		return nil, err
+	}
+	if err := orig.Clone(cloned, options); err != nil {
+		if !options.Snapshot {
+			log.Errorf("Failed to clone container %s as %s. Error: %v", original, cloned, err)
+			return nil, err
+		}
+		log.Warnf("Failed to snapshot container %s as %s, falling back to a full copy. Error: %v", original, cloned, err)
+		if err := destroyPartialClone(cloned); err != nil {
+			return nil, err
+		}
+		if err := orig.Clone(cloned, lxc.CloneOptions{}); err != nil {
+			log.Errorf("Failed to clone container %s as %s. Error: %v", original, cloned, err)
+			return nil, err
+		}
+	}
+	ct, err := lxc.NewContainer(cloned)
+	if err != nil {
+		log.Errorf("Failed to clone container %s as %s. Error: %v", original, cloned, err)
+		return nil, err
+	}
+	return ct, nil
+}
+
+// destroyPartialClone removes what a failed clone left behind
+func destroyPartialClone(name string) error {
+	ct, err := lxc.NewContainer(name)
+	if err != nil {
+		log.Errorf("Failed to initialize container object. Error: %v", err)
+		return err
+	}
+	defer ct.Release()
+	if !ct.Defined() {
+		return nil
+	}
+	if err := ct.Destroy(); err != nil {
+		log.Errorf("Failed to destroy partial clone %s. Error: %v", name, err)
+		return err
+	}
+	return nil
+}
+
+// Rootfs returns the host directory holding the root filesystem of a
+// container. For overlay clones this is the directory holding the changes
+// made to the original container.
+func Rootfs(ct *lxc.Container) string {
+	rootfs := ct.ConfigItem("lxc.rootfs")[0]
+	for _, prefix := range []string{"overlayfs:", "aufs:"} {
+		if strings.HasPrefix(rootfs, prefix) {
+			return rootfs[strings.LastIndex(rootfs, ":")+1:]
+		}
+	}
+	return rootfs
+}
+
+// Path returns the location on the host of a path inside a
+// container. Paths of running containers are resolved through the root of
+// their init process, which works for every backing store.
+func Path(ct *lxc.Container, path string) string {
+	if ct.Running() {
+		if pid := ct.InitPid(); pid > 0 {
+			return filepath.Join("/proc", strconv.Itoa(pid), "root", path)
+		}
+	}
+	return filepath.Join(Rootfs(ct), path)
+}
+
+// Start starts a container and waits for its ip address
+func Start(ct *lxc.Container) error {
+	if err := ct.Start(); err != nil {
+		log.Errorf("Failed to start container %s. Error: %v", ct.Name(), err)
+		return err
+	}
+	log.Infof("Started container named: %s. Waiting for ip allocation", ct.Name())
+	if _, err := ct.WaitIPAddresses(30 * time.Second); err != nil {
+		log.Errorf("Failed to while waiting to start the container %s. Error: %v", ct.Name(), err)
+		return err
+	}
+	return nil
+}
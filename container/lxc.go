@@ -0,0 +1,253 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build lxc
+// +build lxc
+
+package container
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/lxc/go-lxc.v2"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	executor.Register("lxc", &LXC{PoolDir: DefaultPoolDir})
+}
+
+// LXC runs build jobs in clones of LXC containers
+type LXC struct {
+	// PoolDir holds the warm containers of a Pool running on the host
+	PoolDir string
+}
+
+// Create takes a warm container of base from the pool when one is ready, or
+// clones the base container, applies the limits as cgroup settings of the
+// clone and starts it
+func (l *LXC) Create(base string, options executor.CreateOptions) (executor.Environment, error) {
+	if ct := l.claim(base, options.Limits); ct != nil {
+		return &environment{ct: ct}, nil
+	}
+	cloned, err := util.UUID()
+	if err != nil {
+		log.Errorf("Failed to generate uuid. Error: %v", err)
+		return nil, err
+	}
+	clone, err := cloneOptions(options.Clone)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := Clone(base, cloned, clone)
+	if err != nil {
+		log.Errorf("Failed to clone container %s as %s. Error: %v", base, cloned, err)
+		return nil, err
+	}
+	for _, item := range cgroupItems(options.Limits) {
+		if err := ct.SetConfigItem(item[0], item[1]); err != nil {
+			log.Errorf("Failed to set %s of container %s. Error: %v", item[0], cloned, err)
+			ct.Destroy()
+			return nil, err
+		}
+	}
+	if err := Start(ct); err != nil {
+		ct.Destroy()
+		return nil, err
+	}
+	return &environment{ct: ct}, nil
+}
+
+// Exists reports whether the base container is defined on this host
+func (l *LXC) Exists(base string) bool {
+	ct, err := lxc.NewContainer(base)
+	if err != nil {
+		log.Warnf("Failed to initialize container object %s. Error: %v", base, err)
+		return false
+	}
+	defer ct.Release()
+	return ct.Defined()
+}
+
+// claim takes a warm container of base from the pool and applies the limits
+// to its cgroups, nil when no container is ready
+func (l *LXC) claim(base string, limits executor.Limits) *lxc.Container {
+	if l.PoolDir == "" {
+		return nil
+	}
+	backend := new(poolBackend)
+	name, ok := executor.Claim(l.PoolDir, base, backend)
+	if !ok {
+		return nil
+	}
+	ct, err := lxc.NewContainer(name)
+	if err != nil {
+		log.Warnf("Failed to initialize container object %s. Error: %v", name, err)
+		if err := backend.Destroy(name); err != nil {
+			log.Errorf("Failed to destroy pooled container %s. Error: %v", name, err)
+		}
+		return nil
+	}
+	for _, item := range cgroupItems(limits) {
+		key := strings.TrimPrefix(item[0], "lxc.cgroup.")
+		if err := ct.SetCgroupItem(key, item[1]); err != nil {
+			log.Warnf("Failed to set %s of pooled container %s, cloning %s instead. Error: %v", key, name, base, err)
+			if err := destroyContainer(ct); err != nil {
+				log.Errorf("Failed to destroy pooled container %s. Error: %v", name, err)
+			}
+			ct.Release()
+			return nil
+		}
+	}
+	log.Infof("Using pooled container %s of %s", ct.Name(), base)
+	return ct
+}
+
+// cloneOptions maps a clone mode to lxc clone options
+func cloneOptions(mode string) (lxc.CloneOptions, error) {
+	switch mode {
+	case "", "copy":
+		return lxc.CloneOptions{}, nil
+	case "snapshot":
+		return lxc.CloneOptions{Snapshot: true}, nil
+	case "overlayfs":
+		return lxc.CloneOptions{Backend: lxc.Overlayfs, Snapshot: true}, nil
+	case "aufs":
+		return lxc.CloneOptions{Backend: lxc.Aufs, Snapshot: true}, nil
+	case "btrfs":
+		return lxc.CloneOptions{Backend: lxc.Btrfs, Snapshot: true}, nil
+	case "zfs":
+		return lxc.CloneOptions{Backend: lxc.ZFS, Snapshot: true}, nil
+	case "lvm":
+		return lxc.CloneOptions{Backend: lxc.LVM, Snapshot: true}, nil
+	}
+	return lxc.CloneOptions{}, fmt.Errorf("Unknown clone mode: %s", mode)
+}
+
+// cgroupItems returns the container config items applying limits
+func cgroupItems(limits executor.Limits) [][2]string {
+	var items [][2]string
+	if limits.MemoryBytes > 0 {
+		items = append(items, [2]string{"lxc.cgroup.memory.limit_in_bytes", strconv.FormatInt(limits.MemoryBytes, 10)})
+	}
+	if limits.CPUShares > 0 {
+		items = append(items, [2]string{"lxc.cgroup.cpu.shares", strconv.Itoa(limits.CPUShares)})
+	}
+	if limits.Pids > 0 {
+		items = append(items, [2]string{"lxc.cgroup.pids.max", strconv.Itoa(limits.Pids)})
+	}
+	return items
+}
+
+type environment struct {
+	ct *lxc.Container
+}
+
+func (e *environment) Name() string {
+	return e.ct.Name()
+}
+
+func (e *environment) Path(path string) string {
+	return path
+}
+
+func (e *environment) HostPath(path string) string {
+	return Path(e.ct, path)
+}
+
+func (e *environment) Start(args []string, options executor.Options) (executor.Process, error) {
+	attach := lxc.DefaultAttachOptions
+	attach.Env = options.Env
+	attach.ClearEnv = true
+	attach.Cwd = options.Cwd
+	attach.StdoutFd = options.Stdout.Fd()
+	attach.StderrFd = options.Stderr.Fd()
+	pid, err := e.ct.RunCommandNoWait(args, attach)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	return &attachedProcess{ct: e.ct, proc: proc}, nil
+}
+
+func (e *environment) CopyFile(src, dest string) error {
+	return CopyFile(e.ct, src, dest)
+}
+
+// DiskUsage measures the root filesystem directory of the container, the
+// upper directory of overlay clones. Block device backed containers (lvm)
+// are measured through the root of the running container.
+func (e *environment) DiskUsage() (int64, error) {
+	rootfs := Rootfs(e.ct)
+	if info, err := os.Stat(rootfs); err == nil && info.IsDir() {
+		return util.DiskUsage(rootfs)
+	}
+	return util.DiskUsage(e.HostPath("/"))
+}
+
+func (e *environment) Destroy() error {
+	defer e.ct.Release()
+	if err := e.ct.Stop(); err != nil {
+		log.Errorf("Failed to stop container %s. Error: %v", e.ct.Name(), err)
+		return err
+	}
+	return e.ct.Destroy()
+}
+
+// attachedProcess is a process attached to a container
+type attachedProcess struct {
+	ct   *lxc.Container
+	proc *os.Process
+}
+
+func (p *attachedProcess) Wait() (int, error) {
+	state, err := p.proc.Wait()
+	if err != nil {
+		return -1, err
+	}
+	return state.ExitCode(), nil
+}
+
+// Kill kills the process and all processes it started inside the container.
+// The container is frozen meanwhile, so no process can escape by forking.
+func (p *attachedProcess) Kill() error {
+	if err := p.ct.Freeze(); err != nil {
+		log.Warnf("Failed to freeze container %s. Error: %v", p.ct.Name(), err)
+	} else {
+		defer func() {
+			if err := p.ct.Unfreeze(); err != nil {
+				log.Errorf("Failed to unfreeze container %s. Error: %v", p.ct.Name(), err)
+			}
+		}()
+	}
+	pids, err := util.Descendants(p.proc.Pid)
+	if err != nil {
+		log.Errorf("Failed to list processes started by %d. Error: %v", p.proc.Pid, err)
+		return err
+	}
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			log.Warnf("Failed to kill process %d of container %s. Error: %v", pid, p.ct.Name(), err)
+		}
+	}
+	return p.proc.Kill()
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build lxc
+// +build lxc
+
+package container
+
+import (
+	"github.com/ranjib/gypsy/executor"
+	"gopkg.in/lxc/go-lxc.v2"
+	"testing"
+)
+
+func TestCloneOptions(t *testing.T) {
+	tests := []struct {
+		mode    string
+		options lxc.CloneOptions
+	}{
+		{"", lxc.CloneOptions{}},
+		{"copy", lxc.CloneOptions{}},
+		{"snapshot", lxc.CloneOptions{Snapshot: true}},
+		{"overlayfs", lxc.CloneOptions{Backend: lxc.Overlayfs, Snapshot: true}},
+		{"aufs", lxc.CloneOptions{Backend: lxc.Aufs, Snapshot: true}},
+		{"btrfs", lxc.CloneOptions{Backend: lxc.Btrfs, Snapshot: true}},
+		{"zfs", lxc.CloneOptions{Backend: lxc.ZFS, Snapshot: true}},
+		{"lvm", lxc.CloneOptions{Backend: lxc.LVM, Snapshot: true}},
+	}
+	for _, test := range tests {
+		options, err := cloneOptions(test.mode)
+		if err != nil {
+			t.Errorf("cloneOptions(%q) failed: %v", test.mode, err)
+			continue
+		}
+		if options != test.options {
+			t.Errorf("cloneOptions(%q) = %+v, expected %+v", test.mode, options, test.options)
+		}
+	}
+}
+
+func TestCloneOptionsUnknownMode(t *testing.T) {
+	if _, err := cloneOptions("sideways"); err == nil {
+		t.Errorf("expected an error for an unknown clone mode")
+	}
+}
+
+func TestCloneOptionsCoverCloneModes(t *testing.T) {
+	for _, mode := range executor.CloneModes {
+		if _, err := cloneOptions(mode); err != nil {
+			t.Errorf("clone mode %s is not supported: %v", mode, err)
+		}
+	}
+}
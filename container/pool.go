@@ -0,0 +1,98 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build lxc
+// +build lxc
+
+package container
+
+import (
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/lxc/go-lxc.v2"
+)
+
+// DefaultPoolDir is where warm container pools register their containers
+const DefaultPoolDir = "/var/lib/gypsy/pool"
+
+// NewPool creates a pool of warm LXC containers, cloned with the given clone
+// mode
+func NewPool(dir string, size int, clone string, bases []string) (*executor.Pool, error) {
+	options, err := cloneOptions(clone)
+	if err != nil {
+		log.Errorf("Invalid clone mode for container pool. Error: %v", err)
+		return nil, err
+	}
+	return executor.NewPool(dir, size, bases, &poolBackend{clone: options})
+}
+
+// poolBackend clones, starts and destroys pooled LXC containers. Container
+// objects are released once done with, pools run for a long time.
+type poolBackend struct {
+	clone lxc.CloneOptions
+}
+
+func (b *poolBackend) Warm(base string) (string, error) {
+	name, err := util.UUID()
+	if err != nil {
+		log.Errorf("Failed to generate uuid. Error: %v", err)
+		return "", err
+	}
+	ct, err := Clone(base, name, b.clone)
+	if err != nil {
+		return "", err
+	}
+	defer ct.Release()
+	if err := Start(ct); err != nil {
+		if e := ct.Destroy(); e != nil {
+			log.Errorf("Failed to destroy container %s. Error: %v", name, e)
+		}
+		return "", err
+	}
+	return name, nil
+}
+
+func (b *poolBackend) Alive(name string) bool {
+	ct, err := lxc.NewContainer(name)
+	if err != nil {
+		log.Warnf("Failed to initialize container object %s. Error: %v", name, err)
+		return false
+	}
+	defer ct.Release()
+	return ct.Running()
+}
+
+func (b *poolBackend) Destroy(name string) error {
+	ct, err := lxc.NewContainer(name)
+	if err != nil {
+		log.Errorf("Failed to initialize container object %s. Error: %v", name, err)
+		return err
+	}
+	defer ct.Release()
+	if !ct.Defined() {
+		return nil
+	}
+	return destroyContainer(ct)
+}
+
+// destroyContainer stops a container, when running, and destroys it
+func destroyContainer(ct *lxc.Container) error {
+	if ct.Running() {
+		if err := ct.Stop(); err != nil {
+			return err
+		}
+	}
+	return ct.Destroy()
+}
@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build lxc
+// +build lxc
+
 // Provides LXC container building gears from dockerfile like specification
 package dockerfile
 
@@ -19,6 +22,7 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"github.com/ranjib/gypsy/container"
 	"github.com/ranjib/gypsy/util"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/lxc/go-lxc.v2"
@@ -106,7 +110,7 @@ func (spec *Spec) Build() error {
 				return errors.New("Container already built. Multiple FROM declaration?")
 			}
 			var err error
-			spec.State.Container, err = util.CloneAndStartContainer(words[1], spec.ID)
+			spec.State.Container, err = container.CloneAndStart(words[1], spec.ID)
 			if err != nil {
 				log.Errorf("Failed to clone container. Error: %s\n", err)
 				return err
@@ -179,7 +183,7 @@ func (spec *Spec) runCommand(command []string) error {
 	buffer.WriteString(strings.Join(command, " "))
 	err := ioutil.WriteFile(filepath.Join(rootfs, "/tmp/dockerfile.sh"), buffer.Bytes(), 0755)
 	if err != nil {
-		log.Errorf("Failed to open file %s. Error: %v", err)
+		log.Errorf("Failed to write /tmp/dockerfile.sh in %s. Error: %v", rootfs, err)
 		return err
 	}
 
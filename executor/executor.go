@@ -0,0 +1,179 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Provides the environments build jobs run in (LXC containers, local
+// processes etc.)
+package executor
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Default is the executor of pipelines that do not pick one
+const Default = "lxc"
+
+// Builtin are the executors shipped with gypsy. The lxc executor links
+// liblxc and is only registered by binaries built with the lxc build tag,
+// other binaries still accept pipelines using it.
+var Builtin = []string{"local", "lxc"}
+
+// Executor creates the environments build jobs run in
+type Executor interface {
+	// Create prepares a new environment from base, e.g. the container
+	// cloned by the lxc executor
+	Create(base string, options CreateOptions) (Environment, error)
+	// Exists reports whether base can be used to create environments
+	Exists(base string) bool
+}
+
+// CreateOptions configure new environments
+type CreateOptions struct {
+	Limits Limits
+	// Clone is how the base is copied, one of CloneModes. Executors that
+	// can not snapshot their base make a full copy.
+	Clone string
+}
+
+// CloneModes are the supported ways of cloning a base: copy (the default)
+// makes a full copy, snapshot lets the backing store of the base pick a
+// copy-on-write clone, the others snapshot with the named backing store.
+var CloneModes = []string{"copy", "snapshot", "overlayfs", "aufs", "btrfs", "zfs", "lvm"}
+
+// Limits restrict the resources of an environment. Zero values are
+// unlimited. The disk limit is enforced by builds, see Environment.DiskUsage.
+type Limits struct {
+	MemoryBytes int64
+	CPUShares   int
+	Pids        int
+	DiskBytes   int64
+}
+
+// NewLimits converts the resources of a pipeline into limits
+func NewLimits(resources structs.Resources) (Limits, error) {
+	memory, err := structs.ParseSize(resources.Memory)
+	if err != nil {
+		return Limits{}, err
+	}
+	disk, err := structs.ParseSize(resources.Disk)
+	if err != nil {
+		return Limits{}, err
+	}
+	return Limits{
+		MemoryBytes: memory,
+		CPUShares:   resources.CPUShares,
+		Pids:        resources.Pids,
+		DiskBytes:   disk,
+	}, nil
+}
+
+// Environment is an isolated place running the commands of a build job
+type Environment interface {
+	Name() string
+	// Path returns how commands running in the environment see a path of
+	// the environment
+	Path(path string) string
+	// HostPath returns the location of a path of the environment on the host
+	HostPath(path string) string
+	// Start runs a command in the environment without waiting for it
+	Start(args []string, options Options) (Process, error)
+	// CopyFile copies a file of the environment to dest on the host
+	CopyFile(src, dest string) error
+	// DiskUsage returns the bytes written to the environment's file system.
+	// Snapshot clones only count the changes made to their base.
+	DiskUsage() (int64, error)
+	// Destroy stops all processes of the environment and removes it
+	Destroy() error
+}
+
+// Options of commands run in an environment. The environment variables of
+// the host are not passed to commands.
+type Options struct {
+	Env    []string
+	Cwd    string
+	Stdout *os.File
+	Stderr *os.File
+}
+
+// Process is a command started in an environment
+type Process interface {
+	// Wait waits for the process to exit and returns its exit code
+	Wait() (int, error)
+	Kill() error
+}
+
+var (
+	executorsLock sync.RWMutex
+	executors     = make(map[string]Executor)
+)
+
+// Register makes an executor available to pipelines
+func Register(name string, executor Executor) {
+	executorsLock.Lock()
+	defer executorsLock.Unlock()
+	executors[name] = executor
+}
+
+// Types returns the names of the builtin and registered executors
+func Types() []string {
+	executorsLock.RLock()
+	defer executorsLock.RUnlock()
+	types := append([]string{}, Builtin...)
+	for name := range executors {
+		if !Known(name) {
+			types = append(types, name)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Known reports whether pipelines can pick the named executor, the default
+// one when name is empty, even if this binary can't run it
+func Known(name string) bool {
+	if name == "" {
+		name = Default
+	}
+	for _, builtin := range Builtin {
+		if name == builtin {
+			return true
+		}
+	}
+	executorsLock.RLock()
+	defer executorsLock.RUnlock()
+	_, ok := executors[name]
+	return ok
+}
+
+// Get returns the named executor, the default one when name is empty
+func Get(name string) (Executor, error) {
+	if name == "" {
+		name = Default
+	}
+	executorsLock.RLock()
+	defer executorsLock.RUnlock()
+	executor, ok := executors[name]
+	if !ok {
+		for _, builtin := range Builtin {
+			if name == builtin {
+				return nil, fmt.Errorf("Executor %s is not available, gypsy was built without the %s build tag", name, name)
+			}
+		}
+		return nil, fmt.Errorf("Unknown executor: %s", name)
+	}
+	return executor, nil
+}
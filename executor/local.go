@@ -0,0 +1,196 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"github.com/ranjib/gypsy/material"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	Register("local", &Local{WorkDir: os.TempDir()})
+}
+
+// Local runs build jobs as processes of this host. Every job gets its own
+// directory under WorkDir. Pipeline paths (material destinations, working
+// directories, artifacts) are relative to that directory.
+//
+// With a base directory holding a root filesystem, the directory is a copy
+// of it and commands are run chrooted in it, in their own mount, uts, ipc
+// and pid namespaces. This requires root privileges.
+type Local struct {
+	WorkDir string
+}
+
+// Create makes the job directory. Only the disk limit applies to local
+// builds, bases are always fully copied.
+func (l *Local) Create(base string, options CreateOptions) (Environment, error) {
+	limits := options.Limits
+	if limits.MemoryBytes > 0 || limits.CPUShares > 0 || limits.Pids > 0 {
+		log.Warnf("Memory, CPU and process limits are not supported by the local executor")
+	}
+	root, err := ioutil.TempDir(l.WorkDir, "gypsy-")
+	if err != nil {
+		log.Errorf("Failed to create build directory. Error: %v", err)
+		return nil, err
+	}
+	env := &localEnvironment{root: root}
+	if base != "" {
+		if err := material.CopyTree(base, root); err != nil {
+			log.Errorf("Failed to copy root filesystem %s. Error: %v", base, err)
+			os.RemoveAll(root)
+			return nil, err
+		}
+		env.chroot = true
+	}
+	if err := os.MkdirAll(env.HostPath("/tmp"), 01777); err != nil {
+		os.RemoveAll(root)
+		return nil, err
+	}
+	return env, nil
+}
+
+// Exists reports whether base is a root filesystem directory, builds
+// without a base run in an empty directory
+func (l *Local) Exists(base string) bool {
+	if base == "" {
+		return true
+	}
+	info, err := os.Stat(base)
+	return err == nil && info.IsDir()
+}
+
+type localEnvironment struct {
+	root   string
+	chroot bool
+}
+
+func (e *localEnvironment) Name() string {
+	return e.root
+}
+
+func (e *localEnvironment) Path(path string) string {
+	if e.chroot {
+		return path
+	}
+	return e.HostPath(path)
+}
+
+func (e *localEnvironment) HostPath(path string) string {
+	return filepath.Join(e.root, path)
+}
+
+func (e *localEnvironment) Start(args []string, options Options) (Process, error) {
+	if !e.chroot && options.Cwd != "" {
+		// working directories are created on demand, like the build directory
+		if err := os.MkdirAll(options.Cwd, 0755); err != nil {
+			return nil, err
+		}
+	}
+	cmd := &exec.Cmd{
+		Path:   e.lookPath(args[0], options.Env),
+		Args:   args,
+		Env:    options.Env,
+		Dir:    options.Cwd,
+		Stdout: options.Stdout,
+		Stderr: options.Stderr,
+		// a process group, so the whole process tree can be killed
+		SysProcAttr: &syscall.SysProcAttr{Setpgid: true},
+	}
+	if e.chroot {
+		cmd.SysProcAttr.Chroot = e.root
+		cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWPID
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &localProcess{cmd: cmd}, nil
+}
+
+// lookPath resolves a command name with the PATH of the command environment
+func (e *localEnvironment) lookPath(name string, env []string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	for _, v := range env {
+		if !strings.HasPrefix(v, "PATH=") {
+			continue
+		}
+		for _, dir := range filepath.SplitList(strings.TrimPrefix(v, "PATH=")) {
+			path := filepath.Join(dir, name)
+			hostPath := path
+			if e.chroot {
+				hostPath = e.HostPath(path)
+			}
+			if info, err := os.Stat(hostPath); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				return path
+			}
+		}
+	}
+	return name
+}
+
+func (e *localEnvironment) DiskUsage() (int64, error) {
+	return util.DiskUsage(e.root)
+}
+
+func (e *localEnvironment) CopyFile(src, dest string) error {
+	in, err := os.Open(e.HostPath(src))
+	if err != nil {
+		log.Errorf("Failed to open file %s. Error: %v", src, err)
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		log.Errorf("Failed to create file %s. Error: %v", dest, err)
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func (e *localEnvironment) Destroy() error {
+	return os.RemoveAll(e.root)
+}
+
+type localProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p *localProcess) Wait() (int, error) {
+	err := p.cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// Kill kills the process and all processes it started
+func (p *localProcess) Kill() error {
+	return syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+}
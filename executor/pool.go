@@ -0,0 +1,247 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolBackend creates and destroys the containers kept by a Pool
+type PoolBackend interface {
+	// Warm creates and starts a container of base and returns its name
+	Warm(base string) (string, error)
+	// Alive reports whether a pooled container can still be handed out
+	Alive(name string) bool
+	Destroy(name string) error
+}
+
+// Pool keeps started containers of bases ready for build jobs, so builds
+// don't wait for containers to be cloned and started. Ready containers are
+// registered as files, named after the container, in a directory per base
+// under Dir. Executors claim a container by removing its file, which only
+// one build agent can do.
+type Pool struct {
+	Dir string
+	// Size is the number of ready containers kept per base
+	Size    int
+	Bases   []string
+	backend PoolBackend
+	mu      sync.Mutex
+	stats   map[string]*PoolStats
+	wg      sync.WaitGroup
+}
+
+// PoolStats counts the containers of a base
+type PoolStats struct {
+	Base string `json:"base"`
+	// Ready containers are waiting for a build
+	Ready int `json:"ready"`
+	// Warming containers are being created
+	Warming int `json:"warming"`
+	Created int `json:"created"`
+	// Claimed containers were handed out to builds
+	Claimed int `json:"claimed"`
+	Failed  int `json:"failed"`
+}
+
+// NewPool creates the pool directories of the bases
+func NewPool(dir string, size int, bases []string, backend PoolBackend) (*Pool, error) {
+	p := &Pool{
+		Dir:     dir,
+		Size:    size,
+		Bases:   bases,
+		backend: backend,
+		stats:   make(map[string]*PoolStats, len(bases)),
+	}
+	for _, base := range bases {
+		if err := os.MkdirAll(filepath.Join(dir, base), 0755); err != nil {
+			log.Errorf("Failed to create pool directory of %s. Error: %v", base, err)
+			return nil, err
+		}
+		p.stats[base] = &PoolStats{Base: base}
+	}
+	return p, nil
+}
+
+// Run refills the pools every interval until stop is closed, then destroys
+// the ready containers
+func (p *Pool) Run(interval time.Duration, stop <-chan struct{}) {
+	for _, base := range p.Bases {
+		p.prune(base)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, base := range p.Bases {
+			p.refill(base)
+		}
+		select {
+		case <-stop:
+			p.wg.Wait()
+			p.Drain()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats returns the counters of every base
+func (p *Pool) Stats() []PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]PoolStats, 0, len(p.Bases))
+	for _, base := range p.Bases {
+		stats = append(stats, *p.stats[base])
+	}
+	return stats
+}
+
+// Drain destroys the ready containers of every base
+func (p *Pool) Drain() {
+	for _, base := range p.Bases {
+		for {
+			name, ok := Claim(p.Dir, base, p.backend)
+			if !ok {
+				break
+			}
+			log.Infof("Destroying pooled container %s of %s", name, base)
+			if err := p.backend.Destroy(name); err != nil {
+				log.Errorf("Failed to destroy pooled container %s. Error: %v", name, err)
+			}
+		}
+		p.mu.Lock()
+		p.stats[base].Ready = 0
+		p.mu.Unlock()
+	}
+}
+
+// prune unregisters containers that can no longer be handed out, e.g. after
+// a reboot of the host
+func (p *Pool) prune(base string) {
+	for _, name := range poolEntries(filepath.Join(p.Dir, base)) {
+		if p.backend.Alive(name) {
+			continue
+		}
+		log.Warnf("Removing stopped container %s from the pool of %s", name, base)
+		if err := os.Remove(filepath.Join(p.Dir, base, name)); err != nil {
+			// claimed meanwhile
+			continue
+		}
+		if err := p.backend.Destroy(name); err != nil {
+			log.Errorf("Failed to destroy pooled container %s. Error: %v", name, err)
+		}
+	}
+}
+
+// refill counts the containers claimed since the last refill and warms new
+// ones in the background, up to the pool size
+func (p *Pool) refill(base string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats[base]
+	ready := len(poolEntries(filepath.Join(p.Dir, base)))
+	if ready < stats.Ready {
+		stats.Claimed += stats.Ready - ready
+	}
+	stats.Ready = ready
+	for i := stats.Ready + stats.Warming; i < p.Size; i++ {
+		stats.Warming++
+		p.wg.Add(1)
+		go p.warm(base)
+	}
+}
+
+// warm creates a container, then registers it as ready
+func (p *Pool) warm(base string) {
+	defer p.wg.Done()
+	name, err := p.backend.Warm(base)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats[base]
+	stats.Warming--
+	if err != nil {
+		log.Errorf("Failed to warm a container of %s. Error: %v", base, err)
+		stats.Failed++
+		return
+	}
+	if err := p.register(base, name); err != nil {
+		log.Errorf("Failed to register pooled container %s. Error: %v", name, err)
+		stats.Failed++
+		if err := p.backend.Destroy(name); err != nil {
+			log.Errorf("Failed to destroy container %s. Error: %v", name, err)
+		}
+		return
+	}
+	stats.Created++
+	stats.Ready++
+	log.Infof("Container %s of %s is ready", name, base)
+}
+
+// register writes the file of a ready container under a hidden name first,
+// so agents only see started containers
+func (p *Pool) register(base, name string) error {
+	tmp := filepath.Join(p.Dir, base, "."+name)
+	if err := ioutil.WriteFile(tmp, nil, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(p.Dir, base, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Claim takes a ready container of base from the pool at dir and returns its
+// name, false when none is ready. Containers that are no longer alive are
+// destroyed on the way.
+func Claim(dir, base string, backend PoolBackend) (string, bool) {
+	for _, name := range poolEntries(filepath.Join(dir, base)) {
+		if err := os.Remove(filepath.Join(dir, base, name)); err != nil {
+			// claimed by another build
+			continue
+		}
+		if !backend.Alive(name) {
+			log.Warnf("Pooled container %s is not running", name)
+			if err := backend.Destroy(name); err != nil {
+				log.Errorf("Failed to destroy pooled container %s. Error: %v", name, err)
+			}
+			continue
+		}
+		return name, true
+	}
+	return "", false
+}
+
+// poolEntries returns the names of the containers registered in a pool
+// directory
+func poolEntries(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), ".") {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
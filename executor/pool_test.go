@@ -0,0 +1,183 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackend keeps pooled containers in memory
+type fakeBackend struct {
+	mu        sync.Mutex
+	next      int
+	alive     map[string]bool
+	destroyed []string
+	fail      bool
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{alive: make(map[string]bool)}
+}
+
+func (b *fakeBackend) Warm(base string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail {
+		return "", fmt.Errorf("clone failed")
+	}
+	b.next++
+	name := fmt.Sprintf("%s-%d", base, b.next)
+	b.alive[name] = true
+	return name, nil
+}
+
+func (b *fakeBackend) Alive(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.alive[name]
+}
+
+func (b *fakeBackend) Destroy(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.alive, name)
+	b.destroyed = append(b.destroyed, name)
+	return nil
+}
+
+func newTestPool(t *testing.T, size int, backend PoolBackend) *Pool {
+	dir, err := ioutil.TempDir("", "gypsy-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	p, err := NewPool(dir, size, []string{"ubuntu"}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// fill refills the pool and waits for the new containers
+func fill(p *Pool) PoolStats {
+	p.refill("ubuntu")
+	p.wg.Wait()
+	return p.Stats()[0]
+}
+
+func TestPoolRefillClaimDrain(t *testing.T) {
+	backend := newFakeBackend()
+	p := newTestPool(t, 2, backend)
+	stats := fill(p)
+	if stats.Ready != 2 || stats.Created != 2 || stats.Warming != 0 {
+		t.Fatalf("unexpected stats after first refill: %+v", stats)
+	}
+	if n := len(poolEntries(filepath.Join(p.Dir, "ubuntu"))); n != 2 {
+		t.Fatalf("expected 2 registered containers, got %d", n)
+	}
+	name, ok := Claim(p.Dir, "ubuntu", backend)
+	if !ok || !backend.Alive(name) {
+		t.Fatalf("expected to claim a running container, got %q %v", name, ok)
+	}
+	if _, ok := Claim(p.Dir, "other", backend); ok {
+		t.Fatalf("claimed a container of a base without pool")
+	}
+	stats = fill(p)
+	if stats.Ready != 2 || stats.Created != 3 || stats.Claimed != 1 {
+		t.Fatalf("unexpected stats after claim: %+v", stats)
+	}
+	p.Drain()
+	if n := len(poolEntries(filepath.Join(p.Dir, "ubuntu"))); n != 0 {
+		t.Fatalf("expected drained pool, got %d containers", n)
+	}
+	if len(backend.destroyed) != 2 {
+		t.Fatalf("expected the 2 ready containers to be destroyed, got %v", backend.destroyed)
+	}
+	if !backend.Alive(name) {
+		t.Fatalf("drain destroyed the claimed container %s", name)
+	}
+	if stats := p.Stats()[0]; stats.Ready != 0 {
+		t.Fatalf("expected no ready containers, got %+v", stats)
+	}
+}
+
+func TestPoolSkipsStoppedContainers(t *testing.T) {
+	backend := newFakeBackend()
+	p := newTestPool(t, 2, backend)
+	fill(p)
+	names := poolEntries(filepath.Join(p.Dir, "ubuntu"))
+	backend.alive[names[0]] = false
+	name, ok := Claim(p.Dir, "ubuntu", backend)
+	if !ok || name != names[1] {
+		t.Fatalf("expected to claim %s, got %q %v", names[1], name, ok)
+	}
+	if len(backend.destroyed) != 1 || backend.destroyed[0] != names[0] {
+		t.Fatalf("expected stopped container %s to be destroyed, got %v", names[0], backend.destroyed)
+	}
+}
+
+func TestPoolPrune(t *testing.T) {
+	backend := newFakeBackend()
+	p := newTestPool(t, 2, backend)
+	fill(p)
+	names := poolEntries(filepath.Join(p.Dir, "ubuntu"))
+	backend.alive[names[1]] = false
+	p.prune("ubuntu")
+	if left := poolEntries(filepath.Join(p.Dir, "ubuntu")); len(left) != 1 || left[0] != names[0] {
+		t.Fatalf("expected only %s to remain, got %v", names[0], left)
+	}
+	if len(backend.destroyed) != 1 || backend.destroyed[0] != names[1] {
+		t.Fatalf("expected %s to be destroyed, got %v", names[1], backend.destroyed)
+	}
+}
+
+func TestPoolCountsFailures(t *testing.T) {
+	backend := newFakeBackend()
+	backend.fail = true
+	p := newTestPool(t, 2, backend)
+	stats := fill(p)
+	if stats.Failed != 2 || stats.Ready != 0 || stats.Warming != 0 {
+		t.Fatalf("unexpected stats after failed refill: %+v", stats)
+	}
+}
+
+func TestPoolRun(t *testing.T) {
+	backend := newFakeBackend()
+	p := newTestPool(t, 1, backend)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.Run(10*time.Millisecond, stop)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats()[0].Ready != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool was not filled: %+v", p.Stats()[0])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if n := len(poolEntries(filepath.Join(p.Dir, "ubuntu"))); n != 0 {
+		t.Fatalf("expected the pool to be drained on stop, got %d containers", n)
+	}
+}
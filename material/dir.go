@@ -0,0 +1,135 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package material
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Register("dir", NewDirectory)
+}
+
+// Directory material watches a local directory. Its revision is a checksum
+// of file names, sizes and modification times.
+type Directory struct {
+	Path string
+}
+
+func NewDirectory(spec structs.Material, workDir string) (Material, error) {
+	path := strings.TrimPrefix(spec.URI, "file://")
+	if path == "" {
+		return nil, fmt.Errorf("Directory material requires an uri")
+	}
+	return &Directory{Path: path}, nil
+}
+
+func (d *Directory) Latest() (string, error) {
+	hash := sha1.New()
+	err := filepath.Walk(d.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(d.Path, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s %d %d %d\n", rel, info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to walk directory %s. Error: %v", d.Path, err)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (d *Directory) Changes(since string) ([]string, error) {
+	return changedSince(d, since)
+}
+
+// Checkout copies the directory content. Only the current content is
+// available, revision is ignored.
+func (d *Directory) Checkout(revision, dest string) error {
+	return CopyTree(d.Path, dest)
+}
+
+// changedSince is used by materials without revision history, their latest
+// revision is the only change
+func changedSince(m Material, since string) ([]string, error) {
+	latest, err := m.Latest()
+	if err != nil {
+		return nil, err
+	}
+	if latest == since {
+		return []string{}, nil
+	}
+	return []string{latest}, nil
+}
+
+// CopyTree recursively copies src directory into dest, preserving file modes
+// and symbolic links
+func CopyTree(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			log.Warnf("Skipping special file %s", path)
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,165 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package material
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Register("git", NewGit)
+}
+
+// Git material polls any repository reachable by the git command line,
+// including file:// urls
+type Git struct {
+	URI    string
+	Branch string
+	Tag    string
+	mirror string
+}
+
+func NewGit(spec structs.Material, workDir string) (Material, error) {
+	if spec.URI == "" {
+		return nil, fmt.Errorf("Git material requires an uri")
+	}
+	return newGit(spec.URI, spec, workDir), nil
+}
+
+func newGit(uri string, spec structs.Material, workDir string) *Git {
+	g := &Git{
+		URI:    uri,
+		Branch: spec.Branch,
+		Tag:    spec.Tag,
+		mirror: cacheDir(workDir, "git", uri),
+	}
+	if g.Branch == "" {
+		g.Branch = "master"
+	}
+	return g
+}
+
+// name returns the branch or tag name to build, tags take precedence
+func (g *Git) name() string {
+	if g.Tag != "" {
+		return g.Tag
+	}
+	return g.Branch
+}
+
+func (g *Git) Selected() Ref {
+	return Ref{Name: g.name(), Tag: g.Tag != ""}
+}
+
+func (g *Git) ref() string {
+	if g.Tag != "" {
+		return "refs/tags/" + g.Tag
+	}
+	return "refs/heads/" + g.Branch
+}
+
+func (g *Git) Latest() (string, error) {
+	out, err := run("", "git", "ls-remote", "--", g.URI, g.ref())
+	if err != nil {
+		log.Errorf("Failed to list remote refs of %s. Error: %v", g.URI, err)
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("Ref %s not found in %s", g.ref(), g.URI)
+	}
+	return fields[0], nil
+}
+
+func (g *Git) Changes(since string) ([]string, error) {
+	if err := g.fetch(); err != nil {
+		return nil, err
+	}
+	args := []string{"rev-list", "-1", g.ref()}
+	if since != "" {
+		args = []string{"rev-list", since + ".." + g.ref()}
+	}
+	out, err := run(g.mirror, "git", args...)
+	if err != nil {
+		log.Errorf("Failed to list revisions of %s. Error: %v", g.URI, err)
+		return nil, err
+	}
+	return lines(out), nil
+}
+
+func (g *Git) Checkout(revision, dest string) error {
+	if _, err := run("", "git", "clone", "--quiet", "--branch", g.name(), "--", g.URI, dest); err != nil {
+		log.Errorf("Failed to clone %s. Error: %v", g.URI, err)
+		return err
+	}
+	if revision == "" {
+		return nil
+	}
+	// a trailing -- would make revision a path, options are rejected instead
+	if strings.HasPrefix(revision, "-") {
+		return fmt.Errorf("Invalid revision '%s' of %s", revision, g.URI)
+	}
+	if _, err := run(dest, "git", "checkout", "--quiet", revision); err != nil {
+		log.Errorf("Failed to checkout revision %s of %s. Error: %v", revision, g.URI, err)
+		return err
+	}
+	return nil
+}
+
+func (g *Git) Refs() ([]Ref, error) {
+	out, err := run("", "git", "ls-remote", "--heads", "--tags", "--", g.URI)
+	if err != nil {
+		log.Errorf("Failed to list remote refs of %s. Error: %v", g.URI, err)
+		return nil, err
+	}
+	refs := []Ref{}
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasSuffix(fields[1], "^{}") {
+			continue
+		}
+		if strings.HasPrefix(fields[1], "refs/heads/") {
+			refs = append(refs, Ref{Name: strings.TrimPrefix(fields[1], "refs/heads/")})
+		} else if strings.HasPrefix(fields[1], "refs/tags/") {
+			refs = append(refs, Ref{Name: strings.TrimPrefix(fields[1], "refs/tags/"), Tag: true})
+		}
+	}
+	return refs, nil
+}
+
+// fetch keeps a local bare mirror of the repository, used to compute changes
+func (g *Git) fetch() error {
+	if _, err := os.Stat(g.mirror); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(g.mirror), 0755); err != nil {
+			log.Errorf("Failed to create directory %s. Error: %v", filepath.Dir(g.mirror), err)
+			return err
+		}
+		if _, err := run("", "git", "clone", "--quiet", "--mirror", "--", g.URI, g.mirror); err != nil {
+			log.Errorf("Failed to mirror %s. Error: %v", g.URI, err)
+			return err
+		}
+		return nil
+	}
+	if _, err := run(g.mirror, "git", "fetch", "--quiet", "--prune", "origin"); err != nil {
+		log.Errorf("Failed to fetch %s. Error: %v", g.URI, err)
+		return err
+	}
+	return nil
+}
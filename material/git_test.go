@@ -0,0 +1,76 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package material
+
+import (
+	"github.com/ranjib/gypsy/structs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitOptionLikeURI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gypsy-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "pwned")
+	uri := "--upload-pack=touch " + marker
+	g := newGit(uri, structs.Material{URI: uri}, filepath.Join(dir, "work"))
+	if _, err := g.Latest(); err == nil {
+		t.Error("expected listing an option like uri to fail")
+	}
+	if _, err := g.Refs(); err == nil {
+		t.Error("expected listing refs of an option like uri to fail")
+	}
+	if err := g.Checkout("", filepath.Join(dir, "checkout")); err == nil {
+		t.Error("expected cloning an option like uri to fail")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("the uri was run as a git option")
+	}
+}
+
+func TestGitCheckout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gypsy-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+	for _, args := range [][]string{
+		{"init", "--quiet", repo},
+		{"-C", repo, "checkout", "--quiet", "-b", "master"},
+		{"-C", repo, "-c", "user.name=gypsy", "-c", "user.email=gypsy@example.com", "commit", "--quiet", "--allow-empty", "-m", "initial"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, out)
+		}
+	}
+	g := newGit(repo, structs.Material{URI: repo}, filepath.Join(dir, "work"))
+	revision, err := g.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Checkout(revision, filepath.Join(dir, "checkout")); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Checkout("--orphan", filepath.Join(dir, "other")); err == nil {
+		t.Error("expected option like revisions to be rejected")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package material
+
+import (
+	"fmt"
+	"github.com/google/go-github/github"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+func init() {
+	Register("github", NewGithub)
+}
+
+// Github material polls a github repository (owner/repo) through the github
+// api. Changes and checkouts are handled over git.
+type Github struct {
+	*Git
+	Owner string
+	Repo  string
+}
+
+func NewGithub(spec structs.Material, workDir string) (Material, error) {
+	fields := strings.Split(spec.URI, "/")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("Invalid github material uri '%s', expected owner/repo", spec.URI)
+	}
+	return &Github{
+		Git:   newGit("https://github.com/"+spec.URI, spec, workDir),
+		Owner: fields[0],
+		Repo:  fields[1],
+	}, nil
+}
+
+func (g *Github) Latest() (string, error) {
+	client := github.NewClient(nil)
+	log.Infof("Getting current sha at %s/%s", g.Owner, g.Repo)
+	ref, _, err := client.Git.GetRef(g.Owner, g.Repo, strings.TrimPrefix(g.ref(), "refs/"))
+	if err != nil {
+		log.Errorf("Error checking github ref of %s/%s. Error %v", g.Owner, g.Repo, err)
+		return "", err
+	}
+	return *ref.Object.SHA, nil
+}
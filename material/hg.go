@@ -0,0 +1,147 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package material
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Register("hg", NewMercurial)
+}
+
+// Mercurial material polls a repository through the hg command line
+type Mercurial struct {
+	URI    string
+	Branch string
+	Tag    string
+	mirror string
+}
+
+func NewMercurial(spec structs.Material, workDir string) (Material, error) {
+	if spec.URI == "" {
+		return nil, fmt.Errorf("Mercurial material requires an uri")
+	}
+	h := &Mercurial{
+		URI:    spec.URI,
+		Branch: spec.Branch,
+		Tag:    spec.Tag,
+		mirror: cacheDir(workDir, "hg", spec.URI),
+	}
+	if h.Branch == "" {
+		h.Branch = "default"
+	}
+	return h, nil
+}
+
+// rev returns the branch or tag name to build, tags take precedence
+func (h *Mercurial) rev() string {
+	if h.Tag != "" {
+		return h.Tag
+	}
+	return h.Branch
+}
+
+func (h *Mercurial) Selected() Ref {
+	return Ref{Name: h.rev(), Tag: h.Tag != ""}
+}
+
+func (h *Mercurial) Latest() (string, error) {
+	out, err := run("", "hg", "identify", "--debug", "--id", "--rev", h.rev(), "--", h.URI)
+	if err != nil {
+		log.Errorf("Failed to identify %s. Error: %v", h.URI, err)
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (h *Mercurial) Changes(since string) ([]string, error) {
+	if err := h.pull(); err != nil {
+		return nil, err
+	}
+	args := []string{"log", "--rev", fmt.Sprintf("'%s'", h.rev()), "--template", "{node}\\n"}
+	if since != "" {
+		revset := fmt.Sprintf("reverse(ancestors('%s') - ancestors('%s'))", h.rev(), since)
+		args = []string{"log", "--rev", revset, "--template", "{node}\\n"}
+	}
+	out, err := run(h.mirror, "hg", args...)
+	if err != nil {
+		log.Errorf("Failed to list revisions of %s. Error: %v", h.URI, err)
+		return nil, err
+	}
+	return lines(out), nil
+}
+
+func (h *Mercurial) Checkout(revision, dest string) error {
+	if revision == "" {
+		revision = h.rev()
+	}
+	if _, err := run("", "hg", "clone", "--quiet", "--updaterev", revision, "--", h.URI, dest); err != nil {
+		log.Errorf("Failed to clone revision %s of %s. Error: %v", revision, h.URI, err)
+		return err
+	}
+	return nil
+}
+
+func (h *Mercurial) Refs() ([]Ref, error) {
+	if err := h.pull(); err != nil {
+		return nil, err
+	}
+	branches, err := run(h.mirror, "hg", "branches", "--template", "{branch}\\n")
+	if err != nil {
+		log.Errorf("Failed to list branches of %s. Error: %v", h.URI, err)
+		return nil, err
+	}
+	tags, err := run(h.mirror, "hg", "tags", "--template", "{tag}\\n")
+	if err != nil {
+		log.Errorf("Failed to list tags of %s. Error: %v", h.URI, err)
+		return nil, err
+	}
+	refs := []Ref{}
+	for _, branch := range lines(branches) {
+		refs = append(refs, Ref{Name: branch})
+	}
+	for _, tag := range lines(tags) {
+		if tag != "tip" {
+			refs = append(refs, Ref{Name: tag, Tag: true})
+		}
+	}
+	return refs, nil
+}
+
+// pull keeps a local repository without working copy, used to compute changes
+func (h *Mercurial) pull() error {
+	if _, err := os.Stat(h.mirror); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(h.mirror), 0755); err != nil {
+			log.Errorf("Failed to create directory %s. Error: %v", filepath.Dir(h.mirror), err)
+			return err
+		}
+		if _, err := run("", "hg", "clone", "--quiet", "--noupdate", "--", h.URI, h.mirror); err != nil {
+			log.Errorf("Failed to mirror %s. Error: %v", h.URI, err)
+			return err
+		}
+		return nil
+	}
+	if _, err := run(h.mirror, "hg", "pull", "--quiet"); err != nil {
+		log.Errorf("Failed to pull %s. Error: %v", h.URI, err)
+		return err
+	}
+	return nil
+}
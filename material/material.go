@@ -0,0 +1,224 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Provides source material backends (git, mercurial, directories etc.) that
+// drive pipeline builds
+package material
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Material is a source of revisions that can trigger pipeline builds
+type Material interface {
+	// Latest returns the most recent revision of the material
+	Latest() (string, error)
+	// Changes returns the revisions introduced after the since revision,
+	// newest first
+	Changes(since string) ([]string, error)
+	// Checkout fetches the given revision (latest if empty) in dest directory
+	Checkout(revision, dest string) error
+}
+
+// Ref is a branch or a tag of a material
+type Ref struct {
+	Name string
+	Tag  bool
+}
+
+// RefLister is implemented by materials that can enumerate their branches
+// and tags, required for branch and tag patterns
+type RefLister interface {
+	Refs() ([]Ref, error)
+}
+
+// Selector is implemented by materials that build a specific branch or tag
+type Selector interface {
+	Selected() Ref
+}
+
+// Factory creates a material from its pipeline specification. Materials
+// that need local state (e.g. mirrors) keep it under workDir.
+type Factory func(spec structs.Material, workDir string) (Material, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = make(map[string]Factory)
+)
+
+// Register makes a material type available to pipelines
+func Register(name string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	factories[name] = factory
+}
+
+// Types returns the names of all registered material types
+func Types() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	types := make([]string, 0, len(factories))
+	for name := range factories {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New creates a material for the given specification
+func New(spec structs.Material, workDir string) (Material, error) {
+	factoriesLock.RLock()
+	factory, ok := factories[spec.Type]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown material type: %s", spec.Type)
+	}
+	return factory(spec, workDir)
+}
+
+// Key returns an identifier unique to a material specification
+func Key(spec structs.Material) string {
+	key := spec.Type + ":" + spec.URI
+	if spec.Branch != "" {
+		key += "#" + spec.Branch
+	}
+	if spec.Tag != "" {
+		key += "@" + spec.Tag
+	}
+	return key
+}
+
+// Expand resolves branch and tag patterns of a material specification into
+// one specification per matching branch or tag. Specifications without
+// patterns are returned as is.
+func Expand(spec structs.Material, workDir string) ([]structs.Material, error) {
+	if !isPattern(spec.Branch) && !isPattern(spec.Tag) && (spec.Branch == "" || spec.Tag == "") {
+		return []structs.Material{spec}, nil
+	}
+	m, err := New(spec, workDir)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := m.(RefLister)
+	if !ok {
+		return nil, fmt.Errorf("Material type %s does not support branch or tag patterns", spec.Type)
+	}
+	refs, err := lister.Refs()
+	if err != nil {
+		log.Errorf("Failed to list refs of %s. Error: %v", spec.URI, err)
+		return nil, err
+	}
+	specs := []structs.Material{}
+	for _, ref := range refs {
+		pattern := spec.Branch
+		if ref.Tag {
+			pattern = spec.Tag
+		}
+		if matched, _ := path.Match(pattern, ref.Name); pattern == "" || !matched {
+			continue
+		}
+		s := spec
+		s.Branch, s.Tag = "", ""
+		if ref.Tag {
+			s.Tag = ref.Name
+		} else {
+			s.Branch = ref.Name
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+// Select narrows a material specification down to the given branch or tag
+// if it matches the specification's patterns. Patterns that do not match are
+// dropped, so the material falls back to its default branch.
+func Select(spec structs.Material, branch, tag string) structs.Material {
+	if matched, _ := path.Match(spec.Branch, branch); branch != "" && matched {
+		spec.Branch, spec.Tag = branch, ""
+		return spec
+	}
+	if matched, _ := path.Match(spec.Tag, tag); tag != "" && matched {
+		spec.Branch, spec.Tag = "", tag
+		return spec
+	}
+	if isPattern(spec.Branch) {
+		spec.Branch = ""
+	}
+	if isPattern(spec.Tag) {
+		spec.Tag = ""
+	}
+	return spec
+}
+
+// Match reports whether a change on the given branch or tag concerns the
+// material specification, and returns the specification narrowed down to it
+func Match(spec structs.Material, branch, tag string) (structs.Material, bool) {
+	spec = Select(spec, branch, tag)
+	m, err := New(spec, "")
+	if err != nil {
+		return spec, false
+	}
+	selector, ok := m.(Selector)
+	if !ok {
+		return spec, false
+	}
+	ref := selector.Selected()
+	if ref.Tag {
+		return spec, tag != "" && ref.Name == tag
+	}
+	return spec, branch != "" && ref.Name == branch
+}
+
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+func cacheDir(workDir, kind, uri string) string {
+	sum := sha1.Sum([]byte(uri))
+	return filepath.Join(workDir, kind, hex.EncodeToString(sum[:]))
+}
+
+func run(dir, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	log.Debugf("Executing: %s %s", name, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s failed: %v. %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func lines(out string) []string {
+	revisions := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			revisions = append(revisions, line)
+		}
+	}
+	return revisions
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package material
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	Register("tarball", NewTarball)
+}
+
+// Tarball material polls a (optionally gzipped) tar archive served over
+// http. Its revision is the ETag or Last-Modified header of the archive,
+// or the checksum of its content when the server sends neither.
+type Tarball struct {
+	URL string
+}
+
+func NewTarball(spec structs.Material, workDir string) (Material, error) {
+	if !strings.HasPrefix(spec.URI, "http://") && !strings.HasPrefix(spec.URI, "https://") {
+		return nil, fmt.Errorf("Tarball material requires an http(s) uri, got '%s'", spec.URI)
+	}
+	return &Tarball{URL: spec.URI}, nil
+}
+
+func (t *Tarball) Latest() (string, error) {
+	resp, err := http.Head(t.URL)
+	if err != nil {
+		log.Errorf("Failed to perform http head against %s. Error: %v", t.URL, err)
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Non 200 response from %s. Return code: %d", t.URL, resp.StatusCode)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return strings.Trim(etag, `"`), nil
+	}
+	if modified := resp.Header.Get("Last-Modified"); modified != "" {
+		return modified, nil
+	}
+	body, err := t.get()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		log.Errorf("Failed to read %s. Error: %v", t.URL, err)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (t *Tarball) Changes(since string) ([]string, error) {
+	return changedSince(t, since)
+}
+
+// Checkout extracts the archive in dest. Only the currently served archive
+// is available, revision is ignored.
+func (t *Tarball) Checkout(revision, dest string) error {
+	body, err := t.get()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	buffered := bufio.NewReader(body)
+	var reader io.Reader = buffered
+	magic, err := buffered.Peek(2)
+	if err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			log.Errorf("Failed to decompress %s. Error: %v", t.URL, err)
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return untar(reader, dest)
+}
+
+func (t *Tarball) get() (io.ReadCloser, error) {
+	resp, err := http.Get(t.URL)
+	if err != nil {
+		log.Errorf("Failed to perform http get against %s. Error: %v", t.URL, err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Non 200 response from %s. Return code: %d", t.URL, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// untar extracts an archive in dest. Entries must stay within dest: paths
+// leaving it, symlinks pointing outside of it and entries written through
+// symlinks are rejected.
+func untar(r io.Reader, dest string) error {
+	dest = filepath.Clean(dest)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Errorf("Failed to read tar archive. Error: %v", err)
+			return err
+		}
+		target := filepath.Join(dest, header.Name)
+		if !within(dest, target) {
+			return fmt.Errorf("Invalid path '%s' in tar archive", header.Name)
+		}
+		if err := checkNoSymlinks(dest, target); err != nil {
+			return fmt.Errorf("Invalid path '%s' in tar archive, %v", header.Name, err)
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			fw, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(fw, tr); err != nil {
+				fw.Close()
+				return err
+			}
+			if err := fw.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(header.Linkname) || !within(dest, filepath.Join(filepath.Dir(target), header.Linkname)) {
+				return fmt.Errorf("Invalid symlink '%s' to '%s' in tar archive", header.Name, header.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			log.Warnf("Skipping unsupported tar entry %s", header.Name)
+		}
+	}
+}
+
+// within reports whether path is dest or below it
+func within(dest, path string) bool {
+	return path == dest || strings.HasPrefix(path, dest+string(os.PathSeparator))
+}
+
+// checkNoSymlinks fails when a directory between dest and target is a
+// symlink, which would let entries be written through it
+func checkNoSymlinks(dest, target string) error {
+	rel, err := filepath.Rel(dest, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	path := dest
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("it goes through a symlink")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package material
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func archive(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.content))}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// untarDirs returns a destination and an outside directory, which archives
+// must not write to
+func untarDirs(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "gypsy-untar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	dest := filepath.Join(dir, "dest")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{dest, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dest, outside
+}
+
+func TestUntar(t *testing.T) {
+	dest, _ := untarDirs(t)
+	entries := []tarEntry{
+		{name: "src/", typeflag: tar.TypeDir},
+		{name: "src/main.go", typeflag: tar.TypeReg, content: "package main"},
+		{name: "main.go", typeflag: tar.TypeSymlink, linkname: "src/main.go"},
+		{name: "src/self.go", typeflag: tar.TypeSymlink, linkname: "../main.go"},
+	}
+	if err := untar(archive(t, entries), dest); err != nil {
+		t.Fatalf("untar failed: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dest, "main.go"))
+	if err != nil || string(content) != "package main" {
+		t.Fatalf("unexpected content %q, error %v", content, err)
+	}
+}
+
+func TestUntarRejectsEscapes(t *testing.T) {
+	// entries get the outside directory, absolute links point there instead
+	// of e.g. /etc, so a regression can't write to the host
+	tests := []struct {
+		name    string
+		entries func(outside string) []tarEntry
+	}{
+		{"parent path", func(outside string) []tarEntry {
+			return []tarEntry{
+				{name: "../x", typeflag: tar.TypeReg, content: "x"},
+			}
+		}},
+		{"absolute symlink", func(outside string) []tarEntry {
+			return []tarEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "a/cron.d/x", typeflag: tar.TypeReg, content: "x"},
+			}
+		}},
+		{"symlink leaving dest", func(outside string) []tarEntry {
+			return []tarEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "../outside"},
+				{name: "a/x", typeflag: tar.TypeReg, content: "x"},
+			}
+		}},
+		{"nested symlink leaving dest", func(outside string) []tarEntry {
+			return []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "d/a", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+			}
+		}},
+		{"write through symlink", func(outside string) []tarEntry {
+			return []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "d"},
+				{name: "a/x", typeflag: tar.TypeReg, content: "x"},
+			}
+		}},
+	}
+	for _, test := range tests {
+		dest, outside := untarDirs(t)
+		if err := untar(archive(t, test.entries(outside)), dest); err == nil {
+			t.Errorf("%s: expected untar to fail", test.name)
+		}
+		if files, _ := ioutil.ReadDir(outside); len(files) > 0 {
+			t.Errorf("%s: files written outside of dest", test.name)
+		}
+	}
+}
+
+func TestUntarDoesNotFollowExistingSymlinks(t *testing.T) {
+	dest, outside := untarDirs(t)
+	secret := filepath.Join(outside, "secret")
+	if err := ioutil.WriteFile(secret, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dest, "file")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir/secret", "file"} {
+		entries := []tarEntry{{name: name, typeflag: tar.TypeReg, content: "overwritten"}}
+		if err := untar(archive(t, entries), dest); err == nil {
+			t.Errorf("%s: expected untar to fail", name)
+		}
+	}
+	if content, _ := ioutil.ReadFile(secret); string(content) != "keep" {
+		t.Errorf("file outside of dest was overwritten: %q", content)
+	}
+}
@@ -164,7 +164,7 @@ func (s *HttpServer) UploadArtifact(resp http.ResponseWriter, req *http.Request)
 
 	file, handler, err := req.FormFile("artifact")
 	if err != nil {
-		log.Warnf("Failed in form file invocation '%s'. Error: %v", err)
+		log.Warnf("Failed in form file invocation. Error: %v", err)
 		http.Error(resp, err.Error(), http.StatusBadRequest)
 		return
 	}
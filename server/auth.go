@@ -0,0 +1,251 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tokenKey struct{}
+
+// Tokens are stored in the tokens bucket under the sha256 of their value,
+// the value itself is only shown once, when the token is created.
+func tokenHash(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
+// createToken stores a token, replacing any token with the same name, and
+// returns its value
+func createToken(tx *bolt.Tx, token structs.Token) (string, error) {
+	if err := deleteToken(tx, token.Name); err != nil {
+		return "", err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(raw)
+	token.CreatedAt = time.Now()
+	data, err := json.Marshal(&token)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Bucket([]byte("tokens")).Put(tokenHash(secret), data); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// deleteToken removes the token with the given name, if any
+func deleteToken(tx *bolt.Tx, name string) error {
+	b := tx.Bucket([]byte("tokens"))
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var token structs.Token
+		if err := json.Unmarshal(v, &token); err != nil {
+			log.Errorf("Failed to unmarshal token. Error: %v", err)
+			continue
+		}
+		if token.Name == name {
+			return b.Delete(k)
+		}
+	}
+	return nil
+}
+
+func listTokens(tx *bolt.Tx) ([]structs.Token, error) {
+	tokens := []structs.Token{}
+	err := tx.Bucket([]byte("tokens")).ForEach(func(k, v []byte) error {
+		var token structs.Token
+		if err := json.Unmarshal(v, &token); err != nil {
+			return err
+		}
+		tokens = append(tokens, token)
+		return nil
+	})
+	return tokens, err
+}
+
+// runTokenName is the name of the agent token issued for a run
+func runTokenName(pipeline string, runId int) string {
+	return fmt.Sprintf("run:%s:%d", pipeline, runId)
+}
+
+// BootstrapToken creates an admin token when no token exists yet, and writes
+// it to path, readable by the server user only
+func BootstrapToken(db *bolt.DB, path string) error {
+	var secret string
+	err := db.Update(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket([]byte("tokens")).Cursor().First(); k != nil {
+			return nil
+		}
+		var err error
+		secret, err = createToken(tx, structs.Token{Name: "admin", Role: structs.RoleAdmin})
+		return err
+	})
+	if err != nil || secret == "" {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		log.Errorf("Failed to write admin token to %s. Error: %v", path, err)
+		return err
+	}
+	log.Warnf("Created initial admin token, stored in %s", path)
+	return nil
+}
+
+// authenticate returns the token presented as a bearer token in the request
+func (s *HttpServer) authenticate(req *http.Request) (*structs.Token, error) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, fmt.Errorf("Missing bearer token")
+	}
+	secret := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	var token *structs.Token
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte("tokens")).Get(tokenHash(secret))
+		if data == nil {
+			return fmt.Errorf("Invalid token")
+		}
+		token = new(structs.Token)
+		return json.Unmarshal(data, token)
+	})
+	return token, err
+}
+
+// authorize wraps a handler, requiring a token granting role
+func (s *HttpServer) authorize(role structs.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		token, err := s.authenticate(req)
+		if err != nil {
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(resp, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		vars := mux.Vars(req)
+		runId, _ := strconv.Atoi(vars["run_id"])
+		if !token.Allows(role, vars["pipeline_name"], runId) {
+			log.Warnf("Token '%s' (%s) denied %s %s", token.Name, token.Role, req.Method, req.URL.Path)
+			http.Error(resp, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if role == structs.RoleAgent && s.verifyAgents && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+			log.Warnf("Rejected agent request without client certificate: %s %s", req.Method, req.URL.Path)
+			http.Error(resp, "Client certificate required", http.StatusForbidden)
+			return
+		}
+		h(resp, req.WithContext(context.WithValue(req.Context(), tokenKey{}, token)))
+	}
+}
+
+// requestToken returns the token a request was authorized with
+func requestToken(req *http.Request) *structs.Token {
+	token, _ := req.Context().Value(tokenKey{}).(*structs.Token)
+	return token
+}
+
+// REST: /tokens
+func (s *HttpServer) ListTokens(resp http.ResponseWriter, req *http.Request) {
+	var tokens []structs.Token
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		tokens, err = listTokens(tx)
+		return err
+	})
+	if err != nil {
+		log.Errorf("Failed to list tokens: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(tokens)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(js)
+}
+
+type tokenRequest struct {
+	Name string       `json:"name"`
+	Role structs.Role `json:"role"`
+}
+
+// REST: /tokens
+func (s *HttpServer) CreateToken(resp http.ResponseWriter, req *http.Request) {
+	var request tokenRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		log.Warnf("Failed to unmarshal request : %v", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Name == "" || strings.HasPrefix(request.Name, "run:") {
+		http.Error(resp, "Invalid token name", http.StatusBadRequest)
+		return
+	}
+	// agent tokens are issued by the server for each run
+	if !request.Role.Valid() || request.Role == structs.RoleAgent {
+		http.Error(resp, fmt.Sprintf("Invalid role '%s'", request.Role), http.StatusBadRequest)
+		return
+	}
+	var secret string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		secret, err = createToken(tx, structs.Token{Name: request.Name, Role: request.Role})
+		return err
+	})
+	if err != nil {
+		log.Errorf("Failed to create token %s: %v", request.Name, err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof("Created %s token %s", request.Role, request.Name)
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusCreated)
+	json.NewEncoder(resp).Encode(map[string]string{
+		"name":  request.Name,
+		"role":  string(request.Role),
+		"token": secret,
+	})
+}
+
+// REST: /tokens/{token_name}
+func (s *HttpServer) DeleteToken(resp http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["token_name"]
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return deleteToken(tx, name)
+	})
+	if err != nil {
+		log.Errorf("Failed to delete token %s: %v", name, err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
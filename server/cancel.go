@@ -0,0 +1,128 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/build"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Agents get this long to stop a cancelled build, before the nomad job is
+// deregistered and the run is marked cancelled regardless
+const cancelGracePeriod = 2 * time.Minute
+
+// REST: /pipelines/{pipeline_name}/runs/{run_id}/cancel
+func (s *HttpServer) CancelRun(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	r := mux.Vars(req)["run_id"]
+	i, err := strconv.Atoi(r)
+	if err != nil {
+		log.Warnf("Failed to convert run id %s for pipeline '%s'. Error: %v", r, p, err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var run structs.Run
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return updateRun(tx, p, i, func(existing *structs.Run) error {
+			switch {
+			case existing.Status == structs.RunQueued:
+				// not handed to an agent yet, nothing to stop
+				existing.SetStatus(structs.RunCancelled)
+			case existing.Status.CanTransition(structs.RunCancelling):
+				existing.SetStatus(structs.RunCancelling)
+			case existing.Status != structs.RunCancelling:
+				return &invalidTransitionError{from: existing.Status, to: structs.RunCancelling}
+			}
+			run = *existing
+			return nil
+		})
+	})
+	if _, ok := err.(*runNotFoundError); ok {
+		http.Error(resp, err.Error(), http.StatusNotFound)
+		return
+	}
+	if e, ok := err.(*invalidTransitionError); ok {
+		log.Warnf("Rejected cancellation: %v", e)
+		http.Error(resp, e.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to cancel run %d of pipeline %s. Error: %v", i, p, err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if run.Status == structs.RunCancelled {
+		s.queue.Finish(p, i)
+	} else {
+		log.Infof("Cancelling run %d of pipeline %s", i, p)
+		go s.reapCancelled(p, i)
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(resp).Encode(&run); err != nil {
+		log.Errorf("Failed to encode run. Error: %v", err)
+	}
+}
+
+// reapCancelled waits for the agent to stop a cancelling run, then
+// deregisters its nomad job. Runs the agent does not stop within the grace
+// period are marked cancelled by the server.
+func (s *HttpServer) reapCancelled(pipeline string, runId int) {
+	deadline := time.Now().Add(cancelGracePeriod)
+	for {
+		var run structs.Run
+		err := s.db.View(func(tx *bolt.Tx) error {
+			runBucket := tx.Bucket([]byte("runs")).Bucket([]byte(pipeline))
+			if runBucket == nil {
+				return &runNotFoundError{pipeline: pipeline, runId: runId}
+			}
+			data := runBucket.Get(util.Itob(uint64(runId)))
+			if data == nil {
+				return &runNotFoundError{pipeline: pipeline, runId: runId}
+			}
+			return json.Unmarshal(data, &run)
+		})
+		if err != nil {
+			log.Errorf("Failed to load run %d of pipeline %s. Error: %v", runId, pipeline, err)
+			return
+		}
+		if run.Status.Finished() || time.Now().After(deadline) {
+			if run.NomadJobID != "" {
+				build.DeregisterNomadJob(run.NomadAddress, run.NomadRegion, run.NomadJobID)
+			}
+			if run.Status.Finished() {
+				return
+			}
+			log.Warnf("Run %d of pipeline %s was not stopped by its agent, marking it cancelled", runId, pipeline)
+			err := s.db.Update(func(tx *bolt.Tx) error {
+				return updateRunStatus(tx, pipeline, runId, structs.RunCancelled)
+			})
+			if err != nil {
+				log.Errorf("Failed to mark run %d of pipeline %s as cancelled. Error: %v", runId, pipeline, err)
+			}
+			s.queue.Finish(pipeline, runId)
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
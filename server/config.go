@@ -14,27 +14,101 @@
 package server
 
 import (
+	"crypto/tls"
+	"fmt"
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/util"
+	"github.com/ranjib/gypsy/validation"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
+	"os"
+	"strings"
 )
 
 type Config struct {
-	DataDir          string `yaml:"data_dir"`
-	ArtifactDir      string `yaml:"artifact_dir"`
-	BindAddr         string `yaml:"bind_addr"`
-	PollingFrequency int    `yaml:"polling_frequency"`
+	DataDir             string            `yaml:"data_dir"`
+	ArtifactDir         string            `yaml:"artifact_dir"`
+	BindAddr            string            `yaml:"bind_addr"`
+	AdvertiseAddr       string            `yaml:"advertise_addr"`
+	PollingFrequency    int               `yaml:"polling_frequency"`
+	MaxConcurrentBuilds int               `yaml:"max_concurrent_builds"`
+	BaseEnv             map[string]string `yaml:"base_env"`
+	Clone               string            `yaml:"clone"`
+	SecretKey           string            `yaml:"secret_key"`
+	TLSCert             string            `yaml:"tls_cert"`
+	TLSKey              string            `yaml:"tls_key"`
+	TLSClientCA         string            `yaml:"tls_client_ca"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		DataDir:          "data",
-		ArtifactDir:      "data/artifacts",
-		BindAddr:         "127.0.0.1:5678",
-		PollingFrequency: 300,
+		DataDir:             "data",
+		ArtifactDir:         "data/artifacts",
+		BindAddr:            "127.0.0.1:5678",
+		PollingFrequency:    300,
+		MaxConcurrentBuilds: 2,
+		BaseEnv:             util.EnvMap(util.MinimalEnv()),
 	}
 }
 
+// TLS returns the listener TLS configuration, nil when no certificate is
+// configured. With a client CA, client certificates are verified when
+// presented, and required from build agents.
+func (c *Config) TLS() (*tls.Config, error) {
+	if c.TLSCert == "" && c.TLSKey == "" {
+		if c.TLSClientCA != "" {
+			return nil, fmt.Errorf("tls_client_ca requires tls_cert and tls_key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if err != nil {
+		log.Errorf("Failed to load TLS certificate %s. Error: %s\n", c.TLSCert, err)
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if c.TLSClientCA != "" {
+		pool, err := util.CertPool(c.TLSClientCA)
+		if err != nil {
+			log.Errorf("Failed to load TLS client CA %s. Error: %s\n", c.TLSClientCA, err)
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// ServerURL returns the URL build agents reach the server at. It is built from
+// advertise_addr, which can be a full URL or host:port, falling back to the
+// bind address with the host name in place of a wildcard address.
+func (c *Config) ServerURL() string {
+	addr := c.AdvertiseAddr
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	if addr == "" {
+		addr = c.BindAddr
+		if host, port, err := net.SplitHostPort(c.BindAddr); err == nil {
+			if host == "" || host == "0.0.0.0" || host == "::" {
+				if hostname, err := os.Hostname(); err == nil {
+					host = hostname
+				}
+			}
+			addr = net.JoinHostPort(host, port)
+		}
+	}
+	scheme := "http"
+	if c.TLSCert != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + addr
+}
+
 func ConfigFomeFile(file string) (*Config, error) {
 	content, err := ioutil.ReadFile(file)
 	if err != nil {
@@ -42,9 +116,19 @@ func ConfigFomeFile(file string) (*Config, error) {
 		return nil, err
 	}
 	config := DefaultConfig()
+	// a configured base environment replaces the default one
+	baseEnv := config.BaseEnv
+	config.BaseEnv = nil
 	if err := yaml.Unmarshal(content, config); err != nil {
 		log.Errorf("Failed to desrialize configuration file %s. Error: %s\n", file, err)
 		return nil, err
 	}
+	if config.BaseEnv == nil {
+		config.BaseEnv = baseEnv
+	}
+	if !validation.CloneMode(config.Clone) {
+		log.Errorf("Invalid clone mode '%s' in configuration file %s, expected one of %s", config.Clone, file, strings.Join(executor.CloneModes, ", "))
+		return nil, fmt.Errorf("Unknown clone mode: %s", config.Clone)
+	}
 	return config, nil
 }
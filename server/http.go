@@ -14,8 +14,10 @@
 package server
 
 import (
+	"crypto/tls"
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/structs"
 	log "github.com/sirupsen/logrus"
 	"net"
 	"net/http"
@@ -26,23 +28,35 @@ type HttpServer struct {
 	listener         net.Listener
 	addr             string
 	db               *bolt.DB
+	queue            *Queue
+	secrets          *SecretStore
 	artifactLocation string
+	// verifyAgents requires a verified client certificate for agent requests
+	verifyAgents bool
 }
 
-func NewHttpServer(addr, artifactDir string, db *bolt.DB) (*HttpServer, error) {
+func NewHttpServer(addr, artifactDir string, db *bolt.DB, queue *Queue, secrets *SecretStore, tlsConfig *tls.Config) (*HttpServer, error) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Warnf("Failed to create http listener object. Error: %v", err)
 		return nil, err
 	}
-	log.Printf("Gypsy server HTTP endpoint started on: %s", addr)
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		log.Printf("Gypsy server HTTPS endpoint started on: %s", addr)
+	} else {
+		log.Printf("Gypsy server HTTP endpoint started on: %s", addr)
+	}
 	r := mux.NewRouter()
 	srv := &HttpServer{
 		router:           r,
 		listener:         ln,
 		addr:             addr,
 		db:               db,
+		queue:            queue,
+		secrets:          secrets,
 		artifactLocation: artifactDir,
+		verifyAgents:     tlsConfig != nil && tlsConfig.ClientCAs != nil,
 	}
 	srv.registerHandlers()
 	go http.Serve(ln, r)
@@ -56,23 +70,48 @@ func (s *HttpServer) registerHandlers() {
 	}).Methods("GET")
 	//s.router.PathPrefix("/static").Handler(http.StripPrefix("/static", http.FileServer(http.Dir("static/"))))
 	// Pipeline API
-	s.router.HandleFunc("/pipelines", s.ListPipelines).Methods("GET")
-	s.router.HandleFunc("/pipelines/{pipeline_name}", s.ShowPipeline).Methods("GET")
-	s.router.HandleFunc("/pipelines", s.CreatePipeline).Methods("POST")
-	s.router.HandleFunc("/pipelines/{pipeline_name}", s.DeletePipeline).Methods("DELETE")
-	s.router.HandleFunc("/pipelines/{pipeline_name}", s.UpdatePipeline).Methods("PUT")
+	s.router.HandleFunc("/pipelines", s.authorize(structs.RoleViewer, s.ListPipelines)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}", s.authorize(structs.RoleViewer, s.ShowPipeline)).Methods("GET")
+	s.router.HandleFunc("/pipelines", s.authorize(structs.RoleAdmin, s.CreatePipeline)).Methods("POST")
+	s.router.HandleFunc("/pipelines/{pipeline_name}", s.authorize(structs.RoleAdmin, s.DeletePipeline)).Methods("DELETE")
+	s.router.HandleFunc("/pipelines/{pipeline_name}", s.authorize(structs.RoleAdmin, s.UpdatePipeline)).Methods("PUT")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/revisions", s.authorize(structs.RoleViewer, s.ListRevisions)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/revisions/{revision}", s.authorize(structs.RoleViewer, s.ShowRevision)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/revisions/{revision}/diff", s.authorize(structs.RoleViewer, s.DiffRevisions)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/revisions/{revision}/rollback", s.authorize(structs.RoleAdmin, s.RollbackPipeline)).Methods("POST")
 
 	// Run API
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs", s.ListRuns).Methods("GET")
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}", s.ShowRun).Methods("GET")
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}", s.UpdateRun).Methods("POST")
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}", s.DeleteRun).Methods("DELETE")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs", s.authorize(structs.RoleViewer, s.ListRuns)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs", s.authorize(structs.RoleOperator, s.CreateRun)).Methods("POST")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}", s.authorize(structs.RoleViewer, s.ShowRun)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}", s.authorize(structs.RoleAgent, s.UpdateRun)).Methods("POST")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}", s.authorize(structs.RoleAdmin, s.DeleteRun)).Methods("DELETE")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/cancel", s.authorize(structs.RoleOperator, s.CancelRun)).Methods("POST")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/log", s.authorize(structs.RoleViewer, s.ShowLog)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/log", s.authorize(structs.RoleAgent, s.AppendLog)).Methods("POST")
+
+	// Queue API
+	s.router.HandleFunc("/queue", s.authorize(structs.RoleViewer, s.ListQueue)).Methods("GET")
 
 	// Artifact API
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts", s.ListArtifacts).Methods("GET")
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts/{artifact_name}", s.DownloadArtifact).Methods("GET")
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts/{artifact_name}", s.UploadArtifact).Methods("POST")
-	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts/{artifact_name}", s.DeleteArtifact).Methods("DELETE")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts", s.authorize(structs.RoleViewer, s.ListArtifacts)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts/{artifact_name}", s.authorize(structs.RoleViewer, s.DownloadArtifact)).Methods("GET")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts/{artifact_name}", s.authorize(structs.RoleAgent, s.UploadArtifact)).Methods("POST")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/artifacts/{artifact_name}", s.authorize(structs.RoleAdmin, s.DeleteArtifact)).Methods("DELETE")
+
+	// Webhook API, requests are authenticated with the pipeline's webhook secret
+	s.router.HandleFunc("/hooks/{provider}", s.Webhook).Methods("POST")
+
+	// Secrets API
+	s.router.HandleFunc("/secrets", s.authorize(structs.RoleAdmin, s.ListSecrets)).Methods("GET")
+	s.router.HandleFunc("/secrets/{secret_name}", s.authorize(structs.RoleAdmin, s.PutSecret)).Methods("PUT")
+	s.router.HandleFunc("/secrets/{secret_name}", s.authorize(structs.RoleAdmin, s.DeleteSecret)).Methods("DELETE")
+	s.router.HandleFunc("/pipelines/{pipeline_name}/runs/{run_id}/secrets", s.authorize(structs.RoleAgent, s.RunSecrets)).Methods("GET")
+
+	// Token API
+	s.router.HandleFunc("/tokens", s.authorize(structs.RoleAdmin, s.ListTokens)).Methods("GET")
+	s.router.HandleFunc("/tokens", s.authorize(structs.RoleAdmin, s.CreateToken)).Methods("POST")
+	s.router.HandleFunc("/tokens/{token_name}", s.authorize(structs.RoleAdmin, s.DeleteToken)).Methods("DELETE")
 }
 
 func (s *HttpServer) Shutdown() {
@@ -0,0 +1,216 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const logPollInterval = 500 * time.Millisecond
+
+// logBucket returns the bucket holding log chunks of a run, keyed by
+// sequence number
+func logBucket(tx *bolt.Tx, pipeline string, runId int, create bool) (*bolt.Bucket, error) {
+	root := tx.Bucket([]byte("logs"))
+	if root == nil {
+		return nil, fmt.Errorf("Logs bucket not found")
+	}
+	if !create {
+		pipelineBucket := root.Bucket([]byte(pipeline))
+		if pipelineBucket == nil {
+			return nil, nil
+		}
+		return pipelineBucket.Bucket(util.Itob(uint64(runId))), nil
+	}
+	pipelineBucket, err := root.CreateBucketIfNotExists([]byte(pipeline))
+	if err != nil {
+		return nil, err
+	}
+	return pipelineBucket.CreateBucketIfNotExists(util.Itob(uint64(runId)))
+}
+
+// REST: /pipelines/{pipeline_name}/runs/{run_id}/log
+func (s *HttpServer) AppendLog(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	r := mux.Vars(req)["run_id"]
+	i, err := strconv.Atoi(r)
+	if err != nil {
+		log.Warnf("Failed to convert run id %s for pipeline '%s'. Error: %v", r, p, err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var chunks []structs.LogChunk
+	if err := json.NewDecoder(req.Body).Decode(&chunks); err != nil {
+		log.Warnf("Failed to unmarshal request : %v", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err1 := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := logBucket(tx, p, i, true)
+		if err != nil {
+			log.Errorf("Failed to create log bucket for run %d of pipeline %s. Error: %v", i, p, err)
+			return err
+		}
+		values, _, err := s.pipelineSecrets(tx, p)
+		if err != nil && err != errSecretsDisabled {
+			log.Errorf("Failed to read secrets of pipeline %s. Error: %v", p, err)
+			return err
+		}
+		mask := util.SecretMasker(values)
+		for _, chunk := range chunks {
+			chunk.Data = mask(chunk.Data)
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(util.Itob(seq), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err1 != nil {
+		log.Warnf("Failed to store log chunks: %v", err1)
+		http.Error(resp, err1.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// REST: /pipelines/{pipeline_name}/runs/{run_id}/log
+//
+// Serves the run output as plain text, or as server sent events when the
+// client accepts text/event-stream. With follow=true the response is kept
+// open and new output is sent as it arrives, until the run finishes.
+func (s *HttpServer) ShowLog(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	r := mux.Vars(req)["run_id"]
+	i, err := strconv.Atoi(r)
+	if err != nil {
+		log.Warnf("Failed to convert run id %s for pipeline '%s'. Error: %v", r, p, err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	follow := req.URL.Query().Get("follow") == "true"
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	var last uint64
+	if id := req.Header.Get("Last-Event-ID"); sse && id != "" {
+		last, _ = strconv.ParseUint(id, 10, 64)
+	}
+	flusher, _ := resp.(http.Flusher)
+	headerSent := false
+	for {
+		var chunks []structs.LogChunk
+		var seqs []uint64
+		found := false
+		finished := false
+		err := s.db.View(func(tx *bolt.Tx) error {
+			if runBucket := tx.Bucket([]byte("runs")).Bucket([]byte(p)); runBucket != nil {
+				if data := runBucket.Get(util.Itob(uint64(i))); data != nil {
+					var run structs.Run
+					if err := json.Unmarshal(data, &run); err != nil {
+						return err
+					}
+					found = true
+					finished = run.Status.Finished()
+				}
+			}
+			b, err := logBucket(tx, p, i, false)
+			if err != nil || b == nil {
+				return err
+			}
+			c := b.Cursor()
+			for k, v := c.Seek(util.Itob(last + 1)); k != nil; k, v = c.Next() {
+				var chunk structs.LogChunk
+				if err := json.Unmarshal(v, &chunk); err != nil {
+					return err
+				}
+				chunks = append(chunks, chunk)
+				seqs = append(seqs, util.Btoi(k))
+			}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("Failed to read log of run %d of pipeline %s: %v", i, p, err)
+			if !headerSent {
+				http.Error(resp, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		if !headerSent {
+			if !found {
+				log.Warnf("No run found")
+				http.Error(resp, "Not present", http.StatusNotFound)
+				return
+			}
+			if sse {
+				resp.Header().Set("Content-Type", "text/event-stream")
+				resp.Header().Set("Cache-Control", "no-cache")
+			} else {
+				resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			}
+			resp.WriteHeader(http.StatusOK)
+			headerSent = true
+		}
+		for n, chunk := range chunks {
+			if err := writeLogChunk(resp, sse, seqs[n], chunk); err != nil {
+				log.Debugf("Log client went away: %v", err)
+				return
+			}
+			last = seqs[n]
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !follow || finished {
+			if sse {
+				io.WriteString(resp, "event: end\ndata: {}\n\n")
+			}
+			return
+		}
+		select {
+		case <-req.Context().Done():
+			return
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+func writeLogChunk(w io.Writer, sse bool, seq uint64, chunk structs.LogChunk) error {
+	if !sse {
+		_, err := io.WriteString(w, chunk.Data)
+		return err
+	}
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", seq, data)
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/ranjib/gypsy/build"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	nomadPollInterval = 5 * time.Second
+	// Agents get this long to report the result of a run once its nomad
+	// allocation has stopped
+	nomadReportGracePeriod = 30 * time.Second
+)
+
+// watchNomadJob follows the allocations of the nomad job building a run,
+// recording their status on the run. Runs whose allocations stopped without
+// their agent reporting a result are marked errored, or cancelled when they
+// were being cancelled.
+func (q *Queue) watchNomadJob(pipeline string, runId int, job *build.NomadJob) {
+	var stoppedAt time.Time
+	for {
+		time.Sleep(nomadPollInterval)
+		status, description, err := job.Status()
+		if err != nil {
+			log.Warnf("Failed to check nomad job %s of run %d of pipeline %s. Error: %v", job.Job.ID, runId, pipeline, err)
+			continue
+		}
+		done := false
+		abandoned := false
+		err = q.db.Update(func(tx *bolt.Tx) error {
+			return updateRun(tx, pipeline, runId, func(run *structs.Run) error {
+				if run.NomadJobID != job.Job.ID || run.Status.Finished() {
+					done = true
+					return nil
+				}
+				if run.NomadStatus != status {
+					log.Infof("Nomad job %s of run %d of pipeline %s is %s", job.Job.ID, runId, pipeline, status)
+					run.NomadStatus = status
+				}
+				if status == build.NomadPending || status == build.NomadRunning {
+					stoppedAt = time.Time{}
+					return nil
+				}
+				if stoppedAt.IsZero() {
+					stoppedAt = time.Now()
+				}
+				if time.Since(stoppedAt) < nomadReportGracePeriod {
+					return nil
+				}
+				log.Warnf("Nomad job %s of run %d of pipeline %s stopped with status %s, but its agent did not report a result. %s", job.Job.ID, runId, pipeline, status, description)
+				if run.Status == structs.RunCancelling {
+					run.SetStatus(structs.RunCancelled)
+				} else {
+					run.SetStatus(structs.RunErrored)
+				}
+				done = true
+				abandoned = true
+				return nil
+			})
+		})
+		if _, ok := err.(*runNotFoundError); ok {
+			return
+		}
+		if err != nil {
+			log.Errorf("Failed to update run %d of pipeline %s. Error: %v", runId, pipeline, err)
+			continue
+		}
+		if abandoned {
+			q.Finish(pipeline, runId)
+		}
+		if done {
+			return
+		}
+	}
+}
@@ -18,8 +18,8 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/gorilla/mux"
 	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/validation"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net/http"
 )
@@ -75,36 +75,62 @@ func (s *HttpServer) ShowPipeline(resp http.ResponseWriter, req *http.Request) {
 	resp.Write(pipeline)
 }
 
-// REST: /pipelines
-func (s *HttpServer) CreatePipeline(resp http.ResponseWriter, req *http.Request) {
+// readPipeline parses and validates the pipeline configuration sent in a
+// request. Invalid configurations are answered with 422.
+func readPipeline(resp http.ResponseWriter, req *http.Request) (*structs.Pipeline, []byte, bool) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		log.Warnf("Failed to read request body : %v", err)
 		http.Error(resp, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, nil, false
 	}
-	log.Println(string(body[:]))
-	var pipeline structs.Pipeline
-	if err := yaml.Unmarshal(body, &pipeline); err != nil {
-		log.Warnf("Failed to unmarshal request : %v", err)
-		http.Error(resp, err.Error(), http.StatusBadRequest)
-		return
+	result := validation.Document(body)
+	if result.Errors != nil {
+		log.Warnf("Rejected invalid pipeline: %v", result.Errors)
+		writeValidationErrors(resp, result.Errors)
+		return nil, nil, false
 	}
-	if err := req.ParseForm(); err != nil {
-		log.Warnf("Failed to parse form: %v", err)
-		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	for _, warning := range result.Warnings {
+		log.Warnf("Pipeline '%s': %s", result.Pipeline.Name, warning)
+	}
+	return result.Pipeline, body, true
+}
+
+func writeValidationErrors(resp http.ResponseWriter, errs validation.Errors) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusUnprocessableEntity)
+	if err := json.NewEncoder(resp).Encode(map[string]validation.Errors{"errors": errs}); err != nil {
+		log.Errorf("Failed to encode validation errors. Error: %v", err)
+	}
+}
+
+// REST: /pipelines
+func (s *HttpServer) CreatePipeline(resp http.ResponseWriter, req *http.Request) {
+	pipeline, body, ok := readPipeline(resp, req)
+	if !ok {
 		return
 	}
-	err1 := s.db.Update(func(tx *bolt.Tx) error {
+	var revision *structs.PipelineRevision
+	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("pipelines"))
+		if b.Get([]byte(pipeline.Name)) != nil {
+			return errPipelineExists
+		}
 		log.Printf("Creating pipeline: %s", pipeline.Name)
-		return b.Put([]byte(pipeline.Name), body)
+		var err error
+		revision, err = savePipeline(tx, pipeline.Name, body)
+		return err
 	})
-	if err1 != nil {
-		log.Warnf("Failed to create pipeline: %v", err1)
-		http.Error(resp, err1.Error(), http.StatusInternalServerError)
+	if err == errPipelineExists {
+		http.Error(resp, "Pipeline "+pipeline.Name+" already exists, use PUT /pipelines/"+pipeline.Name+" to update it", http.StatusConflict)
 		return
 	}
+	if err != nil {
+		log.Warnf("Failed to create pipeline: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeRevision(resp, http.StatusCreated, revision)
 }
 
 // REST: /pipelines/{pipeline_name}
@@ -113,6 +139,9 @@ func (s *HttpServer) DeletePipeline(resp http.ResponseWriter, req *http.Request)
 	err := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("pipelines"))
 		log.Printf("Deleting pipeline: %s", p)
+		if err := deleteRevisions(tx, p); err != nil {
+			return err
+		}
 		return b.Delete([]byte(p))
 	})
 	if err != nil {
@@ -124,14 +153,33 @@ func (s *HttpServer) DeletePipeline(resp http.ResponseWriter, req *http.Request)
 
 // REST: /pipelines/{pipeline_name}
 func (s *HttpServer) UpdatePipeline(resp http.ResponseWriter, req *http.Request) {
-	vars := mux.Vars(req)
-	p := vars["pipeline_name"]
+	p := mux.Vars(req)["pipeline_name"]
+	pipeline, body, ok := readPipeline(resp, req)
+	if !ok {
+		return
+	}
+	if pipeline.Name != p {
+		writeValidationErrors(resp, validation.Errors{{Field: "name", Message: "does not match pipeline " + p}})
+		return
+	}
+	var revision *structs.PipelineRevision
 	err := s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("pipelines"))
+		if tx.Bucket([]byte("pipelines")).Get([]byte(p)) == nil {
+			return errPipelineNotFound
+		}
 		log.Printf("Updating pipeline: %s", p)
-		return b.Put([]byte(p), []byte("42"))
+		var err error
+		revision, err = savePipeline(tx, p, body)
+		return err
 	})
+	if err == errPipelineNotFound {
+		http.Error(resp, "Not present", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Warnf("Failed to update pipeline: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	writeRevision(resp, http.StatusOK, revision)
 }
@@ -15,18 +15,18 @@ package server
 
 import (
 	"github.com/boltdb/bolt"
-	"github.com/google/go-github/github"
-	"github.com/ranjib/gypsy/build"
+	"github.com/ranjib/gypsy/material"
 	"github.com/ranjib/gypsy/structs"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
-	"strings"
 	"time"
 )
 
 type Poller struct {
-	Splay time.Duration
-	db    *bolt.DB
+	Splay   time.Duration
+	db      *bolt.DB
+	queue   *Queue
+	workDir string
 }
 
 func (p *Poller) Start() {
@@ -38,10 +38,12 @@ func (p *Poller) Start() {
 	}
 }
 
-func NewPoller(splay int, db *bolt.DB) *Poller {
+func NewPoller(splay int, workDir string, db *bolt.DB, queue *Queue) *Poller {
 	poller := Poller{
-		Splay: time.Duration(splay) * time.Second,
-		db:    db,
+		Splay:   time.Duration(splay) * time.Second,
+		db:      db,
+		queue:   queue,
+		workDir: workDir,
 	}
 	go poller.Start()
 	return &poller
@@ -70,57 +72,58 @@ func (p *Poller) poll() error {
 }
 
 func (p *Poller) checkMaterial(pipeline structs.Pipeline) {
-	for _, material := range pipeline.Materials {
-		switch material.Type {
-		case "github":
-			log.Infof("Checking github changes for pipeline %s", pipeline.Name)
-			p.checkGithubMaterial(pipeline, material)
-		default:
-			log.Errorf("Unknown meterial type: %s", material.Type)
+	for _, s := range pipeline.Materials {
+		specs, err := material.Expand(s, p.workDir)
+		if err != nil {
+			log.Errorf("Failed to resolve %s material branches of pipeline %s. Error: %v", s.Type, pipeline.Name, err)
+			continue
+		}
+		for _, spec := range specs {
+			m, err := material.New(spec, p.workDir)
+			if err != nil {
+				log.Errorf("Failed to initialize material of pipeline %s. Error: %v", pipeline.Name, err)
+				continue
+			}
+			log.Infof("Checking %s changes for pipeline %s", material.Key(spec), pipeline.Name)
+			p.checkRevision(pipeline, spec, m)
 		}
 	}
 }
 
-func (p *Poller) checkGithubMaterial(pipeline structs.Pipeline, material structs.Material) {
-	fields := strings.Split(material.URI, "/")
-	client := github.NewClient(nil)
-	log.Infof("Getting current sha at %s/%s for '%s' pipeline", fields[0], fields[1], pipeline.Name)
-	ref, _, err := client.Git.GetRef(fields[0], fields[1], "heads/master")
+func (p *Poller) checkRevision(pipeline structs.Pipeline, spec structs.Material, m material.Material) {
+	revision, err := m.Latest()
 	if err != nil {
-		log.Errorf("Error checking github material ref for %s pipeline. Error %v", pipeline.Name, err)
+		log.Errorf("Error checking %s material revision for %s pipeline. Error %v", spec.Type, pipeline.Name, err)
 		return
 	}
-	var prevSHA []byte
+	key := pollingKey(pipeline.Name, spec)
+	var prevRevision []byte
 	err1 := p.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("pollingStatus"))
-		prevSHA = b.Get([]byte(pipeline.Name))
+		prevRevision = b.Get(key)
 		return nil
 	})
 	if err1 != nil {
-		log.Errorf("Failed to fetch previous head SHA for pipeline: %s. Error: %v", pipeline.Name, err1)
+		log.Errorf("Failed to fetch previous revision for pipeline: %s. Error: %v", pipeline.Name, err1)
 		return
 	}
-	if prevSHA == nil || string(prevSHA[:]) != *ref.Object.SHA {
-		log.Infof("Current SHA (%s) is different than  previously built SHA(%s). Triggering build", *ref.Object.SHA, string(prevSHA[:]))
-		var runId uint64
-		err := p.db.Update(func(tx *bolt.Tx) error {
-			status := tx.Bucket([]byte("pollingStatus"))
-			r := tx.Bucket([]byte("runs"))
-			p, e := r.CreateBucketIfNotExists([]byte(pipeline.Name))
-			if e != nil {
-				log.Errorf("Failed to create pipeline specific run bucket")
-				return e
-			}
-			runId, _ = p.NextSequence()
-			return status.Put([]byte(pipeline.Name), []byte(*ref.Object.SHA))
-		})
-		if err != nil {
-			log.Errorf("Failed to store current head SHA for pipeline: %s. Error: %v", pipeline.Name, err)
-			return
+	if prevRevision == nil || string(prevRevision[:]) != revision {
+		log.Infof("Current revision (%s) is different than  previously built revision(%s). Triggering build", revision, string(prevRevision[:]))
+		if changes, err := m.Changes(string(prevRevision[:])); err != nil {
+			log.Warnf("Failed to list changes of %s material for pipeline %s. Error: %v", spec.Type, pipeline.Name, err)
+		} else {
+			log.Infof("Found %d new revision(s) of %s material for pipeline %s", len(changes), spec.Type, pipeline.Name)
+		}
+		run := &structs.Run{
+			PipelineName: pipeline.Name,
+			Cause:        structs.CausePoll,
+			Branch:       spec.Branch,
+			Tag:          spec.Tag,
+		}
+		if err := p.queue.Trigger(run, &spec, revision); err != nil {
+			log.Errorf("Failed to trigger build for pipeline: %s. Error: %v", pipeline.Name, err)
 		}
-		exitCode := build.BuildPipeline(pipeline.Name, int(runId))
-		log.Infof("Build exit code: %d", exitCode)
 		return
 	}
-	log.Infof("Current SHA (%s) is same as previously built SHA. Skipping build", *ref.Object.SHA)
+	log.Infof("Current revision (%s) is same as previously built revision. Skipping build", revision)
 }
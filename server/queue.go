@@ -0,0 +1,365 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/ranjib/gypsy/build"
+	"github.com/ranjib/gypsy/material"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// deregisterNomadJob stops the nomad job of a run, replaced in tests
+var deregisterNomadJob = build.DeregisterNomadJob
+
+// Queue is a durable FIFO of runs waiting to be built, stored in the queue
+// bucket. At most MaxConcurrent runs are building at any time, a run stays
+// in running state until its final status is posted by the build agent.
+type Queue struct {
+	MaxConcurrent int
+	// ServerURL is passed to build agents to reach the server
+	ServerURL string
+	// BaseEnv is recorded on new runs as their base build environment
+	BaseEnv map[string]string
+	// Clone is recorded on new runs as the default container clone mode
+	Clone  string
+	db     *bolt.DB
+	notify chan struct{}
+}
+
+// NewQueue requeues runs that were in flight when the server stopped and
+// starts dispatching queued runs
+func NewQueue(maxConcurrent int, serverURL string, baseEnv map[string]string, clone string, db *bolt.DB) (*Queue, error) {
+	q := &Queue{
+		MaxConcurrent: maxConcurrent,
+		ServerURL:     serverURL,
+		BaseEnv:       baseEnv,
+		Clone:         clone,
+		db:            db,
+		notify:        make(chan struct{}, 1),
+	}
+	if err := q.requeue(); err != nil {
+		return nil, err
+	}
+	go q.Start()
+	return q, nil
+}
+
+func (q *Queue) Start() {
+	for {
+		q.dispatch()
+		select {
+		case <-q.notify:
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// Trigger stores a new run and queues it. If spec is not nil, the material
+// revision that caused the run is recorded so the poller won't build that
+// revision again.
+func (q *Queue) Trigger(run *structs.Run, spec *structs.Material, revision string) error {
+	if spec != nil && revision != "" {
+		run.Revisions = map[string]string{material.Key(*spec): revision}
+	}
+	if run.BaseEnv == nil {
+		run.BaseEnv = q.BaseEnv
+	}
+	if run.Clone == "" {
+		run.Clone = q.Clone
+	}
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		if e := createRun(tx, run); e != nil {
+			return e
+		}
+		if spec != nil && revision != "" {
+			status := tx.Bucket([]byte("pollingStatus"))
+			if e := status.Put(pollingKey(run.PipelineName, *spec), []byte(revision)); e != nil {
+				return e
+			}
+		}
+		return q.put(tx, run)
+	})
+	if err != nil {
+		log.Errorf("Failed to queue run for pipeline: %s. Error: %v", run.PipelineName, err)
+		return err
+	}
+	log.Infof("Queued run %d of pipeline %s", run.ID, run.PipelineName)
+	q.wakeup()
+	return nil
+}
+
+// Finish marks a run as finished, freeing its build slot
+func (q *Queue) Finish(pipeline string, runId int) error {
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		// the agent token of a finished run is no longer needed
+		if err := deleteToken(tx, runTokenName(pipeline, runId)); err != nil {
+			return err
+		}
+		b := tx.Bucket([]byte("queue"))
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item structs.QueueItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				log.Errorf("Failed to unmarshal queue item. Error: %v", err)
+				continue
+			}
+			if item.Pipeline != pipeline || item.RunID != runId || item.State == structs.QueueFinished {
+				continue
+			}
+			item.State = structs.QueueFinished
+			item.FinishedAt = time.Now()
+			return putItem(b, k, &item)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to mark run %d of pipeline %s as finished. Error: %v", runId, pipeline, err)
+		return err
+	}
+	q.wakeup()
+	return nil
+}
+
+// Items returns the queue content in queuing order
+func (q *Queue) Items() ([]structs.QueueItem, error) {
+	items := []structs.QueueItem{}
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("queue")).ForEach(func(k, v []byte) error {
+			var item structs.QueueItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	return items, err
+}
+
+func (q *Queue) put(tx *bolt.Tx, run *structs.Run) error {
+	b := tx.Bucket([]byte("queue"))
+	if b == nil {
+		return fmt.Errorf("Queue bucket not found")
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	item := &structs.QueueItem{
+		Pipeline: run.PipelineName,
+		RunID:    run.ID,
+		State:    structs.QueueQueued,
+		QueuedAt: time.Now(),
+	}
+	return putItem(b, util.Itob(seq), item)
+}
+
+func (q *Queue) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// requeue puts back runs that were running into queued state. The nomad jobs
+// of these runs are deregistered first, their agents would otherwise keep
+// building them and report results the requeued runs can't accept.
+func (q *Queue) requeue() error {
+	var runs []structs.Run
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("queue")).ForEach(func(k, v []byte) error {
+			var item structs.QueueItem
+			if err := json.Unmarshal(v, &item); err != nil || item.State != structs.QueueRunning {
+				return nil
+			}
+			runBucket := tx.Bucket([]byte("runs")).Bucket([]byte(item.Pipeline))
+			if runBucket == nil {
+				return nil
+			}
+			var run structs.Run
+			if data := runBucket.Get(util.Itob(uint64(item.RunID))); data != nil && json.Unmarshal(data, &run) == nil {
+				runs = append(runs, run)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if run.NomadJobID == "" {
+			continue
+		}
+		if err := deregisterNomadJob(run.NomadAddress, run.NomadRegion, run.NomadJobID); err != nil {
+			log.Warnf("Failed to deregister nomad job %s of run %d of pipeline %s. Error: %v", run.NomadJobID, run.ID, run.PipelineName, err)
+		}
+	}
+	return q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("queue"))
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item structs.QueueItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				log.Errorf("Failed to unmarshal queue item. Error: %v", err)
+				continue
+			}
+			if item.State != structs.QueueRunning {
+				continue
+			}
+			log.Infof("Requeuing run %d of pipeline %s", item.RunID, item.Pipeline)
+			err := updateRun(tx, item.Pipeline, item.RunID, func(run *structs.Run) error {
+				if !run.Status.CanTransition(structs.RunQueued) {
+					return &invalidTransitionError{from: run.Status, to: structs.RunQueued}
+				}
+				run.SetStatus(structs.RunQueued)
+				run.NomadJobID = ""
+				run.NomadStatus = ""
+				return nil
+			})
+			if err != nil {
+				log.Warnf("Failed to requeue run %d of pipeline %s. Error: %v", item.RunID, item.Pipeline, err)
+				item.State = structs.QueueFinished
+				item.FinishedAt = time.Now()
+			} else {
+				item.State = structs.QueueQueued
+			}
+			if err := putItem(b, k, &item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dispatch starts queued runs in order, as long as build slots are available
+func (q *Queue) dispatch() {
+	var ready []structs.QueueItem
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("queue"))
+		running := 0
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var item structs.QueueItem
+			if err := json.Unmarshal(v, &item); err == nil && item.State == structs.QueueRunning {
+				running++
+			}
+		}
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if q.MaxConcurrent > 0 && running >= q.MaxConcurrent {
+				break
+			}
+			var item structs.QueueItem
+			if err := json.Unmarshal(v, &item); err != nil || item.State != structs.QueueQueued {
+				continue
+			}
+			if err := updateRunStatus(tx, item.Pipeline, item.RunID, structs.RunScheduled); err != nil {
+				log.Warnf("Dropping run %d of pipeline %s from queue. Error: %v", item.RunID, item.Pipeline, err)
+				item.State = structs.QueueFinished
+				item.FinishedAt = time.Now()
+				if err := putItem(b, k, &item); err != nil {
+					return err
+				}
+				continue
+			}
+			item.State = structs.QueueRunning
+			item.StartedAt = time.Now()
+			if err := putItem(b, k, &item); err != nil {
+				return err
+			}
+			ready = append(ready, item)
+			running++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to dispatch queued runs. Error: %v", err)
+		return
+	}
+	for _, item := range ready {
+		go q.build(item)
+	}
+}
+
+func (q *Queue) build(item structs.QueueItem) {
+	log.Infof("Starting run %d of pipeline %s", item.RunID, item.Pipeline)
+	var token string
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		token, err = createToken(tx, structs.Token{
+			Name:     runTokenName(item.Pipeline, item.RunID),
+			Role:     structs.RoleAgent,
+			Pipeline: item.Pipeline,
+			RunID:    item.RunID,
+		})
+		return err
+	})
+	if err != nil {
+		// agents can not report anything without a token
+		log.Errorf("Failed to issue agent token for run %d of pipeline %s. Error: %v", item.RunID, item.Pipeline, err)
+		q.abort(item)
+		return
+	}
+	job, err := build.BuildPipeline(q.ServerURL, item.Pipeline, item.RunID, token)
+	if err == nil {
+		err := q.db.Update(func(tx *bolt.Tx) error {
+			return updateRun(tx, item.Pipeline, item.RunID, func(run *structs.Run) error {
+				run.NomadJobID = job.Job.ID
+				run.NomadAddress = job.Address
+				run.NomadRegion = job.Region
+				run.NomadStatus = build.NomadPending
+				return nil
+			})
+		})
+		if err != nil {
+			log.Errorf("Failed to record nomad job of run %d of pipeline %s. Error: %v", item.RunID, item.Pipeline, err)
+		}
+		go q.watchNomadJob(item.Pipeline, item.RunID, job)
+		return
+	}
+	log.Errorf("Failed to schedule run %d of pipeline %s. Error: %v", item.RunID, item.Pipeline, err)
+	q.abort(item)
+}
+
+// abort marks a run that could not be scheduled as errored and frees its
+// build slot
+func (q *Queue) abort(item structs.QueueItem) {
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		return updateRunStatus(tx, item.Pipeline, item.RunID, structs.RunErrored)
+	})
+	if err != nil {
+		log.Errorf("Failed to mark run %d of pipeline %s as errored. Error: %v", item.RunID, item.Pipeline, err)
+	}
+	q.Finish(item.Pipeline, item.RunID)
+}
+
+func putItem(b *bolt.Bucket, key []byte, item *structs.QueueItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		log.Errorf("Failed to marshal queue item. Error: %v", err)
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// pollingKey identifies the polling status of a pipeline material
+func pollingKey(pipeline string, spec structs.Material) []byte {
+	return []byte(pipeline + "/" + material.Key(spec))
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/ranjib/gypsy/build"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	"reflect"
+	"testing"
+)
+
+func TestRequeueDeregistersNomadJobs(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	var deregistered []string
+	deregisterNomadJob = func(address, region, jobID string) error {
+		deregistered = append(deregistered, jobID)
+		return nil
+	}
+	defer func() { deregisterNomadJob = build.DeregisterNomadJob }()
+	q := &Queue{db: db, notify: make(chan struct{}, 1)}
+	running := &structs.Run{PipelineName: "gypsy"}
+	finished := &structs.Run{PipelineName: "gypsy"}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, run := range []*structs.Run{running, finished} {
+			if err := createRun(tx, run); err != nil {
+				return err
+			}
+			if err := q.put(tx, run); err != nil {
+				return err
+			}
+		}
+		if err := updateRun(tx, "gypsy", running.ID, func(run *structs.Run) error {
+			run.SetStatus(structs.RunRunning)
+			run.NomadJobID = "gypsy-gypsy-1"
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := updateRun(tx, "gypsy", finished.ID, func(run *structs.Run) error {
+			run.SetStatus(structs.RunSucceeded)
+			return nil
+		}); err != nil {
+			return err
+		}
+		b := tx.Bucket([]byte("queue"))
+		return b.ForEach(func(k, v []byte) error {
+			var item structs.QueueItem
+			json.Unmarshal(v, &item)
+			item.State = structs.QueueRunning
+			return putItem(b, k, &item)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.requeue(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deregistered, []string{"gypsy-gypsy-1"}) {
+		t.Errorf("expected the nomad job of the running run to be deregistered, got %v", deregistered)
+	}
+	items, err := q.Items()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].State != structs.QueueQueued || items[1].State != structs.QueueFinished {
+		t.Errorf("unexpected queue states %s, %s", items[0].State, items[1].State)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		var run structs.Run
+		json.Unmarshal(tx.Bucket([]byte("runs")).Bucket([]byte("gypsy")).Get(util.Itob(uint64(running.ID))), &run)
+		if run.Status != structs.RunQueued || run.NomadJobID != "" {
+			t.Errorf("expected requeued run without nomad job, got %s %q", run.Status, run.NomadJobID)
+		}
+		return nil
+	})
+}
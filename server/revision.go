@@ -0,0 +1,316 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	"github.com/ranjib/gypsy/validation"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errPipelineExists   = errors.New("Pipeline already exists")
+	errPipelineNotFound = errors.New("Pipeline not found")
+	errRevisionNotFound = errors.New("Revision not found")
+)
+
+// savePipeline stores a pipeline configuration as its current one, and
+// records it as a new revision in the pipelineRevisions bucket
+func savePipeline(tx *bolt.Tx, name string, config []byte) (*structs.PipelineRevision, error) {
+	pipelines := tx.Bucket([]byte("pipelines"))
+	b, err := tx.Bucket([]byte("pipelineRevisions")).CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		log.Errorf("Failed to create revision bucket for pipeline %s. Error: %v", name, err)
+		return nil, err
+	}
+	// pipelines created before revisions were kept start their history
+	// with the configuration being replaced
+	if k, _ := b.Cursor().First(); k == nil {
+		if current := pipelines.Get([]byte(name)); current != nil {
+			if _, err := addRevision(b, name, current); err != nil {
+				return nil, err
+			}
+		}
+	}
+	revision, err := addRevision(b, name, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := pipelines.Put([]byte(name), config); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+func addRevision(b *bolt.Bucket, name string, config []byte) (*structs.PipelineRevision, error) {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	revision := &structs.PipelineRevision{
+		Pipeline:  name,
+		Revision:  int(seq),
+		CreatedAt: time.Now(),
+		Config:    string(config),
+	}
+	data, err := json.Marshal(revision)
+	if err != nil {
+		return nil, err
+	}
+	return revision, b.Put(util.Itob(seq), data)
+}
+
+func getRevision(tx *bolt.Tx, name string, revision int) (*structs.PipelineRevision, error) {
+	b := tx.Bucket([]byte("pipelineRevisions")).Bucket([]byte(name))
+	if b == nil {
+		return nil, errRevisionNotFound
+	}
+	data := b.Get(util.Itob(uint64(revision)))
+	if data == nil {
+		return nil, errRevisionNotFound
+	}
+	r := new(structs.PipelineRevision)
+	return r, json.Unmarshal(data, r)
+}
+
+func latestRevision(tx *bolt.Tx, name string) (int, error) {
+	b := tx.Bucket([]byte("pipelineRevisions")).Bucket([]byte(name))
+	if b == nil {
+		return 0, errRevisionNotFound
+	}
+	k, _ := b.Cursor().Last()
+	if k == nil {
+		return 0, errRevisionNotFound
+	}
+	return int(util.Btoi(k)), nil
+}
+
+func deleteRevisions(tx *bolt.Tx, name string) error {
+	b := tx.Bucket([]byte("pipelineRevisions"))
+	if b.Bucket([]byte(name)) == nil {
+		return nil
+	}
+	return b.DeleteBucket([]byte(name))
+}
+
+func writeRevision(resp http.ResponseWriter, status int, revision *structs.PipelineRevision) {
+	summary := *revision
+	summary.Config = ""
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	if err := json.NewEncoder(resp).Encode(&summary); err != nil {
+		log.Errorf("Failed to encode revision. Error: %v", err)
+	}
+}
+
+func revisionError(resp http.ResponseWriter, err error) {
+	if err == errRevisionNotFound {
+		http.Error(resp, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Errorf("Failed to read pipeline revision: %v", err)
+	http.Error(resp, err.Error(), http.StatusInternalServerError)
+}
+
+// REST: /pipelines/{pipeline_name}/revisions
+func (s *HttpServer) ListRevisions(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	revisions := []structs.PipelineRevision{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("pipelineRevisions")).Bucket([]byte(p))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var revision structs.PipelineRevision
+			if err := json.Unmarshal(v, &revision); err != nil {
+				return err
+			}
+			revision.Config = ""
+			revisions = append(revisions, revision)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Errorf("Failed to list revisions of pipeline %s: %v", p, err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(revisions)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(js)
+}
+
+// REST: /pipelines/{pipeline_name}/revisions/{revision}
+func (s *HttpServer) ShowRevision(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	r, err := strconv.Atoi(mux.Vars(req)["revision"])
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var revision *structs.PipelineRevision
+	err = s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		revision, err = getRevision(tx, p, r)
+		return err
+	})
+	if err != nil {
+		revisionError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/yaml")
+	resp.Write([]byte(revision.Config))
+}
+
+// REST: /pipelines/{pipeline_name}/revisions/{revision}/diff?to={revision}
+// Differences between a revision and another one, the latest by default
+func (s *HttpServer) DiffRevisions(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	from, err := strconv.Atoi(mux.Vars(req)["revision"])
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to := 0
+	if v := req.URL.Query().Get("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var a, b *structs.PipelineRevision
+	err = s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if to == 0 {
+			if to, err = latestRevision(tx, p); err != nil {
+				return err
+			}
+		}
+		if a, err = getRevision(tx, p, from); err != nil {
+			return err
+		}
+		b, err = getRevision(tx, p, to)
+		return err
+	})
+	if err != nil {
+		revisionError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(resp, "--- %s revision %d\n+++ %s revision %d\n", p, a.Revision, p, b.Revision)
+	resp.Write([]byte(diffLines(a.Config, b.Config)))
+}
+
+// REST: /pipelines/{pipeline_name}/revisions/{revision}/rollback
+// Makes a copy of an earlier revision the current pipeline configuration
+func (s *HttpServer) RollbackPipeline(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	r, err := strconv.Atoi(mux.Vars(req)["revision"])
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var revision *structs.PipelineRevision
+	var invalid validation.Errors
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		old, err := getRevision(tx, p, r)
+		if err != nil {
+			return err
+		}
+		var pipeline structs.Pipeline
+		if err := yaml.Unmarshal([]byte(old.Config), &pipeline); err != nil {
+			return err
+		}
+		// revisions may refer to things that are gone, e.g. material types
+		if invalid = validation.Pipeline(&pipeline); invalid != nil {
+			return nil
+		}
+		log.Printf("Rolling back pipeline %s to revision %d", p, r)
+		revision, err = savePipeline(tx, p, []byte(old.Config))
+		return err
+	})
+	if err != nil {
+		revisionError(resp, err)
+		return
+	}
+	if invalid != nil {
+		writeValidationErrors(resp, invalid)
+		return
+	}
+	writeRevision(resp, http.StatusOK, revision)
+}
+
+// diffLines returns the line differences between two texts, prefixing lines
+// only in a with '-', lines only in b with '+' and common lines with ' '
+func diffLines(a, b string) string {
+	x, y := splitLines(a), splitLines(b)
+	// lcs[i][j] is the length of the longest common subsequence of x[i:], y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out bytes.Buffer
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			out.WriteString(" " + x[i] + "\n")
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
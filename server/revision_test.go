@@ -0,0 +1,38 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b, diff string
+	}{
+		{"", "", ""},
+		{"a\n", "a\n", " a\n"},
+		{"", "a\nb\n", "+a\n+b\n"},
+		{"a\nb\n", "", "-a\n-b\n"},
+		{"a\nb\nc\n", "a\nx\nc\n", " a\n-b\n+x\n c\n"},
+		{"a\nb\n", "a\nb\nc", " a\n b\n+c\n"},
+		{"name: gypsy\ncontainer: ubuntu\n", "container: debian\nname: gypsy\n", "+container: debian\n name: gypsy\n-container: ubuntu\n"},
+	}
+	for _, test := range tests {
+		if diff := diffLines(test.a, test.b); diff != test.diff {
+			t.Errorf("diffLines(%q, %q) = %q, expected %q", test.a, test.b, diff, test.diff)
+		}
+	}
+}
@@ -28,6 +28,85 @@ import (
 	"strconv"
 )
 
+// createRun allocates the next id from the pipeline's run bucket and stores
+// the initial run record
+func createRun(tx *bolt.Tx, run *structs.Run) error {
+	b := tx.Bucket([]byte("runs"))
+	runBucket, err := b.CreateBucketIfNotExists([]byte(run.PipelineName))
+	if err != nil {
+		log.Errorf("Failed to create run bucket for pipeline %s. Error: %v", run.PipelineName, err)
+		return err
+	}
+	id, err := runBucket.NextSequence()
+	if err != nil {
+		log.Errorf("Failed to allocate run id for pipeline %s. Error: %v", run.PipelineName, err)
+		return err
+	}
+	run.ID = int(id)
+	run.SetStatus(structs.RunQueued)
+	data, err := json.Marshal(run)
+	if err != nil {
+		log.Errorf("Failed to marshal run data. Error: %v", err)
+		return err
+	}
+	return runBucket.Put(util.Itob(id), data)
+}
+
+type invalidTransitionError struct {
+	from structs.RunStatus
+	to   structs.RunStatus
+}
+
+func (e *invalidTransitionError) Error() string {
+	return fmt.Sprintf("Invalid run status transition from '%s' to '%s'", e.from, e.to)
+}
+
+// updateRunStatus moves a stored run to the given status
+func updateRunStatus(tx *bolt.Tx, pipeline string, runId int, status structs.RunStatus) error {
+	return updateRun(tx, pipeline, runId, func(run *structs.Run) error {
+		if !run.Status.CanTransition(status) {
+			return &invalidTransitionError{from: run.Status, to: status}
+		}
+		run.SetStatus(status)
+		return nil
+	})
+}
+
+type runNotFoundError struct {
+	pipeline string
+	runId    int
+}
+
+func (e *runNotFoundError) Error() string {
+	return fmt.Sprintf("Run %d of pipeline %s was not found", e.runId, e.pipeline)
+}
+
+// updateRun applies fn to a stored run and saves it
+func updateRun(tx *bolt.Tx, pipeline string, runId int, fn func(*structs.Run) error) error {
+	runBucket := tx.Bucket([]byte("runs")).Bucket([]byte(pipeline))
+	if runBucket == nil {
+		return &runNotFoundError{pipeline: pipeline, runId: runId}
+	}
+	data := runBucket.Get(util.Itob(uint64(runId)))
+	if data == nil {
+		return &runNotFoundError{pipeline: pipeline, runId: runId}
+	}
+	var run structs.Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		log.Errorf("Failed to unmarshal run data. Error: %v", err)
+		return err
+	}
+	if err := fn(&run); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&run)
+	if err != nil {
+		log.Errorf("Failed to marshal run data. Error: %v", err)
+		return err
+	}
+	return runBucket.Put(util.Itob(uint64(runId)), data)
+}
+
 // REST: /pipelines/{pipeline_name}/runs
 func (s *HttpServer) ListRuns(resp http.ResponseWriter, req *http.Request) {
 	p := mux.Vars(req)["pipeline_name"]
@@ -57,6 +136,59 @@ func (s *HttpServer) ListRuns(resp http.ResponseWriter, req *http.Request) {
 	resp.Write(js)
 }
 
+// REST: /pipelines/{pipeline_name}/runs
+func (s *HttpServer) CreateRun(resp http.ResponseWriter, req *http.Request) {
+	p := mux.Vars(req)["pipeline_name"]
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		log.Warnf("Failed to read request body : %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var runRequest structs.RunRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &runRequest); err != nil {
+			log.Warnf("Failed to unmarshal request : %v", err)
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	run := &structs.Run{
+		PipelineName: p,
+		Cause:        structs.CauseManual,
+		Branch:       runRequest.Branch,
+		Revision:     runRequest.Revision,
+		Parameters:   runRequest.Parameters,
+		Env:          runRequest.Env,
+	}
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte("pipelines")).Get([]byte(p)) != nil
+		return nil
+	})
+	if !found {
+		log.Warnf("No pipeline found")
+		http.Error(resp, "Not present", http.StatusNotFound)
+		return
+	}
+	log.Printf("Triggering run for pipeline: %s", p)
+	if err := s.queue.Trigger(run, nil, ""); err != nil {
+		log.Errorf("Failed to create run: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(run)
+	if err != nil {
+		log.Errorf("Failed to marshal json: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Location", fmt.Sprintf("/pipelines/%s/runs/%d", p, run.ID))
+	resp.WriteHeader(http.StatusCreated)
+	resp.Write(js)
+}
+
 // REST: /pipelines/{pipeline_name}/runs/{run_id}
 func (s *HttpServer) ShowRun(resp http.ResponseWriter, req *http.Request) {
 	p := mux.Vars(req)["pipeline_name"]
@@ -104,13 +236,13 @@ func (s *HttpServer) UpdateRun(resp http.ResponseWriter, req *http.Request) {
 		http.Error(resp, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	log.Println(string(body[:]))
 	var run structs.Run
 	if err := json.Unmarshal(body, &run); err != nil {
 		log.Warnf("Failed to unmarshal request : %v", err)
 		http.Error(resp, err.Error(), http.StatusBadRequest)
 		return
 	}
+	log.Infof("Received run %d of pipeline '%s' with status '%s'", i, p, run.Status)
 	log.Infof("Run data validation succeeded, saving data")
 	err1 := s.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("runs"))
@@ -120,13 +252,61 @@ func (s *HttpServer) UpdateRun(resp http.ResponseWriter, req *http.Request) {
 			log.Errorln("Failed to create sub bucket")
 			return e
 		}
+		if previous := runBucket.Get(util.Itob(uint64(i))); previous != nil {
+			var existing structs.Run
+			if e := json.Unmarshal(previous, &existing); e == nil {
+				if !existing.Status.CanTransition(run.Status) {
+					return &invalidTransitionError{from: existing.Status, to: run.Status}
+				}
+				if run.NomadJobID == "" {
+					// recorded by the server after the agent fetched the run
+					run.NomadJobID = existing.NomadJobID
+					run.NomadAddress = existing.NomadAddress
+					run.NomadRegion = existing.NomadRegion
+					run.NomadStatus = existing.NomadStatus
+				}
+			}
+		}
+		if e := s.maskRun(tx, p, &run); e != nil {
+			log.Errorf("Failed to mask secrets in run output. Error: %v", e)
+			return e
+		}
+		if body, e = json.Marshal(&run); e != nil {
+			return e
+		}
 		return runBucket.Put(util.Itob(uint64(i)), body)
 	})
+	if e, ok := err1.(*invalidTransitionError); ok {
+		log.Warnf("Rejected run update: %v", e)
+		http.Error(resp, e.Error(), http.StatusConflict)
+		return
+	}
 	if err1 != nil {
 		log.Warnf("Failed to store run details: %v", err1)
 		http.Error(resp, err1.Error(), http.StatusInternalServerError)
 		return
 	}
+	if run.Status.Finished() {
+		s.queue.Finish(p, i)
+	}
+}
+
+// REST: /queue
+func (s *HttpServer) ListQueue(resp http.ResponseWriter, req *http.Request) {
+	items, err := s.queue.Items()
+	if err != nil {
+		log.Errorf("Failed to list queue: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(items)
+	if err != nil {
+		log.Errorf("Failed to marshal json: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(js)
 }
 
 // REST: /pipelines/{pipeline_name}/runs/{run_id}
@@ -151,6 +331,12 @@ func (s *HttpServer) DeleteRun(resp http.ResponseWriter, req *http.Request) {
 			return fmt.Errorf("Run bucket for pipeline %s was not found", p)
 		}
 		log.Printf("Deleting run '%s' for pipeline: %s", r, p)
+		if logs := tx.Bucket([]byte("logs")).Bucket([]byte(p)); logs != nil && logs.Bucket(util.Itob(uint64(i))) != nil {
+			if e := logs.DeleteBucket(util.Itob(uint64(i))); e != nil {
+				log.Errorf("Failed to delete log of run %d for pipeline %s", i, p)
+				return e
+			}
+		}
 		return pipeline.Delete(util.Itob(uint64(i)))
 	})
 	if err != nil {
@@ -0,0 +1,284 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/structs"
+	"github.com/ranjib/gypsy/util"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errSecretsDisabled = errors.New("Secret store is not configured, set secret_key in the server configuration")
+	errRunNotActive    = errors.New("Secrets are only available to runs being built")
+)
+
+// SecretStore keeps secrets in the secrets bucket, encrypted with AES-GCM
+// using a key derived from the configured secret key
+type SecretStore struct {
+	aead cipher.AEAD
+}
+
+// NewSecretStore returns a secret store. Stores without a key are disabled.
+func NewSecretStore(key string) (*SecretStore, error) {
+	if key == "" {
+		return &SecretStore{}, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretStore{aead: aead}, nil
+}
+
+func (s *SecretStore) put(tx *bolt.Tx, name, value string) error {
+	if s.aead == nil {
+		return errSecretsDisabled
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	// the name is authenticated, values can not be swapped between secrets
+	data := s.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return tx.Bucket([]byte("secrets")).Put([]byte(name), data)
+}
+
+func (s *SecretStore) get(tx *bolt.Tx, name string) (string, bool, error) {
+	if s.aead == nil {
+		return "", false, errSecretsDisabled
+	}
+	data := tx.Bucket([]byte("secrets")).Get([]byte(name))
+	if data == nil {
+		return "", false, nil
+	}
+	n := s.aead.NonceSize()
+	if len(data) < n {
+		return "", false, fmt.Errorf("Secret %s is corrupted", name)
+	}
+	value, err := s.aead.Open(nil, data[:n], data[n:], []byte(name))
+	if err != nil {
+		log.Errorf("Failed to decrypt secret %s. Error: %v", name, err)
+		return "", false, err
+	}
+	return string(value), true, nil
+}
+
+// pipelineSecrets returns the values of the secrets referenced by a pipeline
+// and the names of referenced secrets that do not exist
+func (s *HttpServer) pipelineSecrets(tx *bolt.Tx, name string) (map[string]string, []string, error) {
+	data := tx.Bucket([]byte("pipelines")).Get([]byte(name))
+	if data == nil {
+		return nil, nil, nil
+	}
+	var pipeline structs.Pipeline
+	if err := yaml.Unmarshal(data, &pipeline); err != nil {
+		log.Errorf("Failed to unmarshal pipeline %s. Error: %v", name, err)
+		return nil, nil, err
+	}
+	values := make(map[string]string)
+	var missing []string
+	for _, secret := range pipeline.SecretNames() {
+		value, found, err := s.secrets.get(tx, secret)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !found {
+			missing = append(missing, secret)
+			continue
+		}
+		values[secret] = value
+	}
+	return values, missing, nil
+}
+
+// maskRun replaces secret values in the output recorded on a run
+func (s *HttpServer) maskRun(tx *bolt.Tx, pipeline string, run *structs.Run) error {
+	values, _, err := s.pipelineSecrets(tx, pipeline)
+	if err == errSecretsDisabled {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mask := util.SecretMasker(values)
+	run.Stdout = mask(run.Stdout)
+	run.Stderr = mask(run.Stderr)
+	for i := range run.Stages {
+		for j := range run.Stages[i].Jobs {
+			job := &run.Stages[i].Jobs[j]
+			job.Stdout = mask(job.Stdout)
+			job.Stderr = mask(job.Stderr)
+		}
+	}
+	return nil
+}
+
+// REST: /secrets
+func (s *HttpServer) ListSecrets(resp http.ResponseWriter, req *http.Request) {
+	names := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("secrets")).ForEach(func(k, v []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		log.Errorf("Failed to list secrets: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	js, err := json.Marshal(names)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(js)
+}
+
+// REST: /secrets/{secret_name}
+func (s *HttpServer) PutSecret(resp http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["secret_name"]
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		log.Warnf("Failed to read request body : %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return s.secrets.put(tx, name, string(body))
+	})
+	if err == errSecretsDisabled {
+		http.Error(resp, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to store secret %s: %v", name, err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof("Stored secret %s", name)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// REST: /secrets/{secret_name}
+func (s *HttpServer) DeleteSecret(resp http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["secret_name"]
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("secrets"))
+		found = b.Get([]byte(name)) != nil
+		return b.Delete([]byte(name))
+	})
+	if err != nil {
+		log.Errorf("Failed to delete secret %s: %v", name, err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(resp, "Not present", http.StatusNotFound)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// REST: /pipelines/{pipeline_name}/runs/{run_id}/secrets
+// Values of the secrets referenced by the pipeline, for the agent building
+// the run. Only available while the run is being built.
+func (s *HttpServer) RunSecrets(resp http.ResponseWriter, req *http.Request) {
+	if token := requestToken(req); token == nil || token.Role != structs.RoleAgent {
+		http.Error(resp, "Secrets are only available to build agents", http.StatusForbidden)
+		return
+	}
+	p := mux.Vars(req)["pipeline_name"]
+	r := mux.Vars(req)["run_id"]
+	i, err := strconv.Atoi(r)
+	if err != nil {
+		log.Warnf("Failed to convert run id %s for pipeline '%s'. Error: %v", r, p, err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var values map[string]string
+	var missing []string
+	err = s.db.View(func(tx *bolt.Tx) error {
+		runBucket := tx.Bucket([]byte("runs")).Bucket([]byte(p))
+		if runBucket == nil {
+			return &runNotFoundError{pipeline: p, runId: i}
+		}
+		data := runBucket.Get(util.Itob(uint64(i)))
+		if data == nil {
+			return &runNotFoundError{pipeline: p, runId: i}
+		}
+		var run structs.Run
+		if err := json.Unmarshal(data, &run); err != nil {
+			return err
+		}
+		if run.Status != structs.RunScheduled && run.Status != structs.RunRunning {
+			return errRunNotActive
+		}
+		var e error
+		values, missing, e = s.pipelineSecrets(tx, p)
+		return e
+	})
+	if _, ok := err.(*runNotFoundError); ok {
+		http.Error(resp, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == errRunNotActive {
+		http.Error(resp, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err == errSecretsDisabled {
+		http.Error(resp, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to read secrets of pipeline %s: %v", p, err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(missing) > 0 {
+		http.Error(resp, "Missing secrets: "+strings.Join(missing, ", "), http.StatusUnprocessableEntity)
+		return
+	}
+	js, err := json.Marshal(values)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(js)
+}
@@ -0,0 +1,380 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/material"
+	"github.com/ranjib/gypsy/structs"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const maxHookPayload = 10 << 20
+
+// zeroRevision is the revision git servers report for deleted refs
+const zeroRevision = "0000000000000000000000000000000000000000"
+
+// pushEvent is the provider independent content of a push notification
+type pushEvent struct {
+	Repositories []string
+	Branch       string
+	Tag          string
+	Revision     string
+	// Deleted is set for pushes deleting the branch or tag
+	Deleted bool
+}
+
+type hookProvider struct {
+	// event returns false for notifications other than pushes
+	event func(req *http.Request) bool
+	parse func(body []byte) (*pushEvent, error)
+	// verify checks the request signature against the pipeline secret
+	verify func(req *http.Request, body []byte, secret string) bool
+}
+
+var hookProviders = map[string]hookProvider{
+	"github": {
+		event: func(req *http.Request) bool {
+			return req.Header.Get("X-GitHub-Event") == "push"
+		},
+		parse: parseGithubPush,
+		verify: func(req *http.Request, body []byte, secret string) bool {
+			if sig := req.Header.Get("X-Hub-Signature-256"); sig != "" {
+				return verifyHMAC(sha256.New, sig, "sha256=", body, secret)
+			}
+			return verifyHMAC(sha1.New, req.Header.Get("X-Hub-Signature"), "sha1=", body, secret)
+		},
+	},
+	"gitea": {
+		event: func(req *http.Request) bool {
+			return req.Header.Get("X-Gitea-Event") == "push"
+		},
+		parse: parseGithubPush,
+		verify: func(req *http.Request, body []byte, secret string) bool {
+			return verifyHMAC(sha256.New, req.Header.Get("X-Gitea-Signature"), "", body, secret)
+		},
+	},
+	"gitlab": {
+		event: func(req *http.Request) bool {
+			e := req.Header.Get("X-Gitlab-Event")
+			return e == "Push Hook" || e == "Tag Push Hook"
+		},
+		parse: parseGitlabPush,
+		verify: func(req *http.Request, body []byte, secret string) bool {
+			token := req.Header.Get("X-Gitlab-Token")
+			return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+		},
+	},
+	"generic": {
+		event: func(req *http.Request) bool {
+			return true
+		},
+		parse: parseGenericPush,
+		verify: func(req *http.Request, body []byte, secret string) bool {
+			return verifyHMAC(sha256.New, req.Header.Get("X-Gypsy-Signature"), "sha256=", body, secret)
+		},
+	},
+}
+
+type hookRun struct {
+	Pipeline string `json:"pipeline"`
+	RunID    int    `json:"run_id"`
+}
+
+// REST: /hooks/{provider}
+func (s *HttpServer) Webhook(resp http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["provider"]
+	provider, ok := hookProviders[name]
+	if !ok {
+		log.Warnf("Unknown webhook provider '%s'", name)
+		http.Error(resp, "Unknown webhook provider", http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxHookPayload))
+	if err != nil {
+		log.Warnf("Failed to read request body : %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	runs := []hookRun{}
+	if !provider.event(req) {
+		log.Infof("Ignoring %s webhook event other than push", name)
+		writeHookRuns(resp, http.StatusOK, runs)
+		return
+	}
+	event, err := provider.parse(body)
+	if err != nil {
+		log.Warnf("Failed to parse %s webhook payload: %v", name, err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if event.Deleted {
+		log.Infof("Ignoring %s webhook push deleting %s%s", name, event.Branch, event.Tag)
+		writeHookRuns(resp, http.StatusOK, runs)
+		return
+	}
+	var pipelines []structs.Pipeline
+	hookSecrets := make(map[string]string)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("pipelines"))
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var pipeline structs.Pipeline
+			if err := yaml.Unmarshal(v, &pipeline); err != nil {
+				log.Errorf("Failed to unmarshal yaml definition for pipeline %s. Error:%v", string(k[:]), err)
+				continue
+			}
+			pipelines = append(pipelines, pipeline)
+			if secret := s.webhookSecret(tx, &pipeline); secret != "" {
+				hookSecrets[pipeline.Name] = secret
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to list pipelines: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unauthorized := false
+	for _, pipeline := range pipelines {
+		for _, spec := range pipeline.Materials {
+			if !event.concerns(spec) {
+				continue
+			}
+			concrete, ok := material.Match(spec, event.Branch, event.Tag)
+			if !ok {
+				continue
+			}
+			secret := hookSecrets[pipeline.Name]
+			if secret == "" || !provider.verify(req, body, secret) {
+				log.Warnf("Webhook signature verification failed for pipeline %s", pipeline.Name)
+				unauthorized = true
+				continue
+			}
+			log.Infof("Webhook push on %s (%s) triggers pipeline %s", material.Key(concrete), event.Revision, pipeline.Name)
+			run := &structs.Run{
+				PipelineName: pipeline.Name,
+				Cause:        structs.CauseWebhook,
+				Branch:       concrete.Branch,
+				Tag:          concrete.Tag,
+			}
+			if err := s.queue.Trigger(run, &concrete, event.Revision); err != nil {
+				http.Error(resp, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			runs = append(runs, hookRun{Pipeline: pipeline.Name, RunID: run.ID})
+			break
+		}
+	}
+	if len(runs) == 0 && unauthorized {
+		http.Error(resp, "Signature verification failed", http.StatusUnauthorized)
+		return
+	}
+	status := http.StatusOK
+	if len(runs) > 0 {
+		status = http.StatusAccepted
+	}
+	writeHookRuns(resp, status, runs)
+}
+
+// webhookSecret returns the value of the secret referenced by the pipeline's
+// webhook_secret, or an empty string when it can not be resolved
+func (s *HttpServer) webhookSecret(tx *bolt.Tx, pipeline *structs.Pipeline) string {
+	if pipeline.WebhookSecret == "" {
+		return ""
+	}
+	name, ok := structs.SecretRef(pipeline.WebhookSecret)
+	if !ok {
+		log.Warnf("Webhook secret of pipeline %s is not a secret reference (%sNAME), ignoring it", pipeline.Name, structs.SecretPrefix)
+		return ""
+	}
+	value, found, err := s.secrets.get(tx, name)
+	if err != nil {
+		log.Errorf("Failed to read webhook secret %s of pipeline %s. Error: %v", name, pipeline.Name, err)
+		return ""
+	}
+	if !found {
+		log.Warnf("Webhook secret %s of pipeline %s does not exist", name, pipeline.Name)
+		return ""
+	}
+	return value
+}
+
+func writeHookRuns(resp http.ResponseWriter, status int, runs []hookRun) {
+	js, err := json.Marshal(runs)
+	if err != nil {
+		log.Errorf("Failed to marshal json: %v", err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(js)
+}
+
+// concerns reports whether the pushed repository is the material's one
+func (e *pushEvent) concerns(spec structs.Material) bool {
+	id := repositoryID(spec.URI)
+	if spec.Type == "github" {
+		id = "github.com/" + strings.ToLower(strings.Trim(spec.URI, "/"))
+	}
+	for _, repo := range e.Repositories {
+		if repo != "" && repositoryID(repo) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// repositoryID normalizes http(s), ssh and scp like repository urls into
+// host/path form
+func repositoryID(uri string) string {
+	uri = strings.TrimSpace(uri)
+	if u, err := url.Parse(uri); err == nil && u.Scheme != "" && u.Host != "" {
+		uri = u.Hostname() + u.Path
+	} else if i := strings.Index(uri, ":"); i > 0 && !strings.Contains(uri[:i], "/") {
+		// scp like syntax: git@host:owner/repo.git
+		host := uri[:i]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		uri = host + "/" + uri[i+1:]
+	}
+	uri = strings.TrimSuffix(strings.TrimSuffix(uri, "/"), ".git")
+	return strings.ToLower(uri)
+}
+
+func splitRef(ref string) (branch, tag string) {
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return "", strings.TrimPrefix(ref, "refs/tags/")
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), ""
+}
+
+func verifyHMAC(h func() hash.Hash, signature, prefix string, body []byte, secret string) bool {
+	if !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func parseGithubPush(body []byte) (*pushEvent, error) {
+	var payload struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Deleted    bool   `json:"deleted"`
+		Repository struct {
+			FullName string `json:"full_name"`
+			CloneURL string `json:"clone_url"`
+			SSHURL   string `json:"ssh_url"`
+			HTMLURL  string `json:"html_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Ref == "" {
+		return nil, fmt.Errorf("Push payload without ref")
+	}
+	event := &pushEvent{
+		Revision: payload.After,
+		Deleted:  payload.Deleted || payload.After == zeroRevision,
+		Repositories: []string{
+			payload.Repository.CloneURL,
+			payload.Repository.SSHURL,
+			payload.Repository.HTMLURL,
+		},
+	}
+	event.Branch, event.Tag = splitRef(payload.Ref)
+	return event, nil
+}
+
+func parseGitlabPush(body []byte) (*pushEvent, error) {
+	var payload struct {
+		Ref         string `json:"ref"`
+		After       string `json:"after"`
+		CheckoutSHA string `json:"checkout_sha"`
+		Project     struct {
+			GitHTTPURL string `json:"git_http_url"`
+			GitSSHURL  string `json:"git_ssh_url"`
+			WebURL     string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Ref == "" {
+		return nil, fmt.Errorf("Push payload without ref")
+	}
+	event := &pushEvent{
+		Revision: payload.CheckoutSHA,
+		Repositories: []string{
+			payload.Project.GitHTTPURL,
+			payload.Project.GitSSHURL,
+			payload.Project.WebURL,
+		},
+	}
+	if event.Revision == "" {
+		event.Revision = payload.After
+	}
+	event.Deleted = payload.After == zeroRevision
+	event.Branch, event.Tag = splitRef(payload.Ref)
+	return event, nil
+}
+
+// parseGenericPush handles {"repository": url, "ref": ref, "revision": sha}
+// payloads, for git servers without a dedicated provider
+func parseGenericPush(body []byte) (*pushEvent, error) {
+	var payload struct {
+		Repository string `json:"repository"`
+		Ref        string `json:"ref"`
+		Revision   string `json:"revision"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Repository == "" || payload.Ref == "" {
+		return nil, fmt.Errorf("Push payload requires repository and ref")
+	}
+	event := &pushEvent{
+		Revision:     payload.Revision,
+		Repositories: []string{payload.Repository},
+		Deleted:      payload.Revision == zeroRevision,
+	}
+	event.Branch, event.Tag = splitRef(payload.Ref)
+	return event, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/ranjib/gypsy/structs"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDB(t *testing.T) (*bolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "gypsy-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "gypsy.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{"pipelines", "runs", "pollingStatus", "queue", "logs", "secrets", "tokens"} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	secrets, err := NewSecretStore("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &HttpServer{db: db, secrets: secrets}
+	if err := db.Update(func(tx *bolt.Tx) error { return secrets.put(tx, "hook", "s3cr3t") }); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		webhookSecret string
+		value         string
+	}{
+		{"secret:hook", "s3cr3t"},
+		{"secret:missing", ""},
+		// plain text secrets are not accepted anymore
+		{"s3cr3t", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		pipeline := &structs.Pipeline{Name: "gypsy", WebhookSecret: test.webhookSecret}
+		db.View(func(tx *bolt.Tx) error {
+			if value := s.webhookSecret(tx, pipeline); value != test.value {
+				t.Errorf("%q: expected %q, got %q", test.webhookSecret, test.value, value)
+			}
+			return nil
+		})
+	}
+	disabled := &HttpServer{db: db, secrets: &SecretStore{}}
+	db.View(func(tx *bolt.Tx) error {
+		if value := disabled.webhookSecret(tx, &structs.Pipeline{Name: "gypsy", WebhookSecret: "secret:hook"}); value != "" {
+			t.Errorf("expected no secret without a secret store, got %q", value)
+		}
+		return nil
+	})
+}
+
+func sign(h func() hash.Hash, body []byte, secret string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	tests := []struct {
+		h         func() hash.Hash
+		signature string
+		prefix    string
+		valid     bool
+	}{
+		{sha256.New, "sha256=" + sign(sha256.New, body, "secret"), "sha256=", true},
+		{sha1.New, "sha1=" + sign(sha1.New, body, "secret"), "sha1=", true},
+		{sha256.New, sign(sha256.New, body, "secret"), "", true},
+		{sha256.New, sign(sha256.New, body, "secret"), "sha256=", false},
+		{sha256.New, "sha256=" + sign(sha256.New, body, "other"), "sha256=", false},
+		{sha256.New, "sha256=" + sign(sha1.New, body, "secret"), "sha256=", false},
+		{sha256.New, "sha256=not-hex", "sha256=", false},
+		{sha256.New, "", "sha256=", false},
+		{sha256.New, "", "", false},
+	}
+	for i, test := range tests {
+		if valid := verifyHMAC(test.h, test.signature, test.prefix, body, "secret"); valid != test.valid {
+			t.Errorf("%d: expected valid=%v for signature %q", i, test.valid, test.signature)
+		}
+	}
+}
+
+func TestRepositoryID(t *testing.T) {
+	expected := "github.com/ranjib/gypsy"
+	for _, uri := range []string{
+		"https://github.com/ranjib/gypsy.git",
+		"http://GitHub.com/ranjib/gypsy/",
+		"ssh://git@github.com:22/ranjib/gypsy.git",
+		"git@github.com:ranjib/gypsy.git",
+	} {
+		if id := repositoryID(uri); id != expected {
+			t.Errorf("%q: expected %q, got %q", uri, expected, id)
+		}
+	}
+}
+
+func TestParseDeletedPush(t *testing.T) {
+	tests := []struct {
+		parse   func([]byte) (*pushEvent, error)
+		payload string
+		deleted bool
+	}{
+		{parseGithubPush, `{"ref": "refs/heads/topic", "after": "` + zeroRevision + `", "deleted": true}`, true},
+		{parseGithubPush, `{"ref": "refs/heads/topic", "after": "` + zeroRevision + `"}`, true},
+		{parseGithubPush, `{"ref": "refs/heads/topic", "after": "9f1c2e4"}`, false},
+		{parseGitlabPush, `{"ref": "refs/heads/topic", "after": "` + zeroRevision + `", "checkout_sha": null}`, true},
+		{parseGitlabPush, `{"ref": "refs/tags/v1", "after": "9f1c2e4", "checkout_sha": "9f1c2e4"}`, false},
+		{parseGenericPush, `{"repository": "https://git.example.com/gypsy.git", "ref": "refs/heads/topic", "revision": "` + zeroRevision + `"}`, true},
+	}
+	for _, test := range tests {
+		event, err := test.parse([]byte(test.payload))
+		if err != nil {
+			t.Errorf("%s: %v", test.payload, err)
+			continue
+		}
+		if event.Deleted != test.deleted {
+			t.Errorf("%s: expected deleted=%v", test.payload, test.deleted)
+		}
+	}
+}
+
+func TestWebhookIgnoresDeletedBranches(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+	err := db.Update(func(tx *bolt.Tx) error {
+		pipeline := "name: gypsy\nmaterials:\n  - type: git\n    uri: https://github.com/ranjib/gypsy.git\n    branch: topic\n"
+		return tx.Bucket([]byte("pipelines")).Put([]byte("gypsy"), []byte(pipeline))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server has no queue, triggering a run would fail
+	s := &HttpServer{db: db, secrets: &SecretStore{}}
+	router := mux.NewRouter()
+	router.HandleFunc("/hooks/{provider}", s.Webhook)
+	payload := `{"ref": "refs/heads/topic", "after": "` + zeroRevision + `", "deleted": true, "repository": {"clone_url": "https://github.com/ranjib/gypsy.git"}}`
+	req := httptest.NewRequest("POST", "/hooks/github", strings.NewReader(payload))
+	req.Header.Set("X-GitHub-Event", "push")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK || strings.TrimSpace(resp.Body.String()) != "[]" {
+		t.Errorf("expected no runs to be triggered, got %d %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structs
+
+// NomadConfig controls how builds of a pipeline are scheduled on Nomad. Unset
+// fields take the defaults of the Nomad client and of Gypsy.
+type NomadConfig struct {
+	// Address of the Nomad API, defaults to NOMAD_ADDR
+	Address string
+	// Command is the gypsy binary on Nomad clients, run as `gypsy agent`
+	// with the raw_exec driver
+	Command     string
+	Region      string
+	Datacenters []string
+	Priority    int
+	Constraints []NomadConstraint
+	Resources   NomadResources
+}
+
+// NomadConstraint restricts the Nomad clients a build can be placed on, e.g.
+// attribute ${attr.kernel.name}, operator =, value linux
+type NomadConstraint struct {
+	Attribute string
+	Operator  string
+	Value     string
+}
+
+// NomadResources are reserved on the Nomad client running a build
+type NomadResources struct {
+	CPU      int `yaml:"cpu"`
+	MemoryMB int `yaml:"memory_mb"`
+	DiskMB   int `yaml:"disk_mb"`
+}
@@ -15,9 +15,23 @@
 // Holds common data types for gypsy
 package structs
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Material is a source of changes for a pipeline. Branch and Tag select the
+// refs to build, both accept glob patterns (e.g. release/*), in which case
+// every matching branch or tag is polled and built on its own.
 type Material struct {
 	Type     string
 	URI      string `yaml:"uri"`
+	Branch   string
+	Tag      string
+	Dest     string
 	Metadata map[string]string
 }
 
@@ -26,9 +40,37 @@ type Artifact struct {
 	Name string
 }
 
+// Command is a step of a job. Command and Script are run by Shell (bash,
+// sh, or none to split Command on whitespace), Args are executed as is.
 type Command struct {
 	Command string
+	// Script is a multi-line shell script, run in place of Command
+	Script string
+	// Args is an argument vector executed without a shell
+	Args  []string
+	Shell string
+	// Errexit runs the script with `set -e`
+	Errexit bool
 	Cwd     string
+	// Timeout is a duration (e.g. 10m) after which the command is killed
+	Timeout string
+}
+
+// Job is a set of scripts run inside its own container. Jobs of the same
+// stage are run in parallel.
+type Job struct {
+	Name      string
+	Container string
+	Scripts   []Command
+	Artifacts []Artifact
+}
+
+// Stage groups jobs. A stage is run only after all the stages it depends on
+// have succeeded.
+type Stage struct {
+	Name      string
+	DependsOn []string `yaml:"depends_on"`
+	Jobs      []Job
 }
 
 type Pipeline struct {
@@ -37,12 +79,184 @@ type Pipeline struct {
 	Artifacts []Artifact
 	Scripts   []Command
 	Container string
+	Stages    []Stage
+	// WebhookSecret references the secret (secret:NAME) that webhook
+	// notifications triggering the pipeline must be signed with
+	WebhookSecret string `yaml:"webhook_secret"`
+	// Env is exported to every build command. Values of the form secret:NAME
+	// are replaced with the named secret from the server's secret store.
+	Env map[string]string
+	// Parameters are exported to build commands as well, with defaults that
+	// can be overridden when triggering a run
+	Parameters map[string]string
+	// Timeout is a duration (e.g. 1h) after which the whole build is stopped
+	Timeout string
+	// Resources limit the build containers of the pipeline
+	Resources Resources
+	// Executor runs the build jobs: lxc (default) clones the job containers,
+	// local runs commands as processes of the build host
+	Executor string
+	// Clone is how the lxc executor copies job containers: copy (default),
+	// snapshot, or a copy-on-write backing store (overlayfs, aufs, btrfs,
+	// zfs, lvm). Overrides the server's clone setting.
+	Clone string
+	// Nomad configures the Nomad jobs running the builds
+	Nomad NomadConfig
+}
+
+// PipelineRevision is an accepted version of a pipeline configuration
+type PipelineRevision struct {
+	Pipeline  string    `json:"pipeline"`
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	Config    string    `json:"config,omitempty"`
+}
+
+// SecretPrefix marks env and parameter values referencing a secret
+const SecretPrefix = "secret:"
+
+// SecretRef returns the name of the secret referenced by a value
+func SecretRef(value string) (string, bool) {
+	if !strings.HasPrefix(value, SecretPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(value, SecretPrefix), true
+}
+
+// SecretNames returns the names of secrets referenced by the pipeline
+func (p *Pipeline) SecretNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, values := range []map[string]string{p.Env, p.Parameters} {
+		for _, v := range values {
+			if name, ok := SecretRef(v); ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resources limits the containers building a pipeline. Zero values are
+// unlimited.
+type Resources struct {
+	// Memory is a size like 512M or 2G
+	Memory string
+	// CPUShares is the relative CPU weight of the containers, 1024 by default
+	CPUShares int `yaml:"cpu_shares"`
+	// Pids is the maximum number of processes
+	Pids int
+	// Disk is the maximum size of the container file systems, like 10G
+	Disk string
+}
+
+// ParseSize converts a size like 512M or 2G into bytes. Empty sizes return
+// zero.
+func ParseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * uint(i+1))
+			s = s[:n-1]
+		}
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("Invalid size '%s', expected a number with an optional K, M, G or T suffix", size)
+	}
+	return value * multiplier, nil
+}
+
+// ParseTimeout converts a timeout setting into a duration. Empty timeouts
+// return zero, meaning no timeout.
+func ParseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("Negative timeout '%s'", timeout)
+	}
+	return d, nil
 }
 
-type Run struct {
-	ID           int    `json:"id"`
-	PipelineName string `json:"pipeline_name"`
-	Stdout       string `json:"stdout"`
-	Stderr       string `json:"stderr"`
-	Success      bool   `json:"success"`
+// OrderedStages returns the pipeline stages sorted by their dependencies.
+// Stages without a dependency relation retain their declaration order. Jobs
+// that do not specify a container inherit the pipeline container. Pipelines
+// without explicit stages are treated as a single stage with one job built
+// from the top level scripts, container and artifacts.
+func (p *Pipeline) OrderedStages() ([]Stage, error) {
+	if len(p.Stages) == 0 {
+		job := Job{
+			Name:      "default",
+			Container: p.Container,
+			Scripts:   p.Scripts,
+			Artifacts: p.Artifacts,
+		}
+		return []Stage{{Name: "default", Jobs: []Job{job}}}, nil
+	}
+	stages := make(map[string]Stage, len(p.Stages))
+	for _, stage := range p.Stages {
+		if stage.Name == "" {
+			return nil, fmt.Errorf("Stage name must not be empty")
+		}
+		if _, ok := stages[stage.Name]; ok {
+			return nil, fmt.Errorf("Duplicate stage '%s'", stage.Name)
+		}
+		jobs := make([]Job, len(stage.Jobs))
+		for i, job := range stage.Jobs {
+			if job.Name == "" {
+				job.Name = fmt.Sprintf("job-%d", i+1)
+			}
+			if job.Container == "" {
+				job.Container = p.Container
+			}
+			jobs[i] = job
+		}
+		stage.Jobs = jobs
+		stages[stage.Name] = stage
+	}
+	for _, stage := range p.Stages {
+		for _, dep := range stage.DependsOn {
+			if _, ok := stages[dep]; !ok {
+				return nil, fmt.Errorf("Stage '%s' depends on unknown stage '%s'", stage.Name, dep)
+			}
+		}
+	}
+	ordered := make([]Stage, 0, len(p.Stages))
+	done := make(map[string]bool, len(p.Stages))
+	for len(ordered) < len(p.Stages) {
+		progress := false
+		for _, s := range p.Stages {
+			if done[s.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range s.DependsOn {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, stages[s.Name])
+				done[s.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("Circular dependency between stages of pipeline '%s'", p.Name)
+		}
+	}
+	return ordered, nil
 }
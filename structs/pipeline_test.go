@@ -0,0 +1,146 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stageNames(stages []Stage) []string {
+	var names []string
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+	}
+	return names
+}
+
+func TestOrderedStages(t *testing.T) {
+	p := &Pipeline{
+		Name:      "gypsy",
+		Container: "ubuntu",
+		Stages: []Stage{
+			{Name: "deploy", DependsOn: []string{"test", "package"}},
+			{Name: "build", Jobs: []Job{{Container: "debian"}, {Name: "lint"}}},
+			{Name: "package", DependsOn: []string{"build"}},
+			{Name: "test", DependsOn: []string{"build"}},
+		},
+	}
+	stages, err := p.OrderedStages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"build", "package", "test", "deploy"}
+	if names := stageNames(stages); !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected stages %v, got %v", expected, names)
+	}
+	jobs := stages[0].Jobs
+	if jobs[0].Name != "job-1" || jobs[0].Container != "debian" {
+		t.Errorf("unexpected first job %+v", jobs[0])
+	}
+	if jobs[1].Name != "lint" || jobs[1].Container != "ubuntu" {
+		t.Errorf("expected job to inherit the pipeline container, got %+v", jobs[1])
+	}
+}
+
+func TestOrderedStagesDefault(t *testing.T) {
+	p := &Pipeline{Container: "ubuntu", Scripts: []Command{{Command: "make"}}}
+	stages, err := p.OrderedStages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != 1 || len(stages[0].Jobs) != 1 {
+		t.Fatalf("expected a single default job, got %+v", stages)
+	}
+	job := stages[0].Jobs[0]
+	if job.Name != "default" || job.Container != "ubuntu" || len(job.Scripts) != 1 {
+		t.Errorf("unexpected default job %+v", job)
+	}
+}
+
+func TestOrderedStagesErrors(t *testing.T) {
+	tests := []struct {
+		stages []Stage
+		err    string
+	}{
+		{[]Stage{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}, "Circular dependency"},
+		{[]Stage{{Name: "a", DependsOn: []string{"a"}}}, "Circular dependency"},
+		{[]Stage{{Name: "a", DependsOn: []string{"missing"}}}, "unknown stage 'missing'"},
+		{[]Stage{{Name: "a"}, {Name: "a"}}, "Duplicate stage 'a'"},
+		{[]Stage{{Name: ""}}, "must not be empty"},
+	}
+	for _, test := range tests {
+		p := &Pipeline{Name: "gypsy", Stages: test.stages}
+		_, err := p.OrderedStages()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", test.stages, test.err, err)
+		}
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		timeout  string
+		duration time.Duration
+		valid    bool
+	}{
+		{"", 0, true},
+		{"90s", 90 * time.Second, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"-5m", 0, false},
+		{"10", 0, false},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		d, err := ParseTimeout(test.timeout)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid=%v, got error %v", test.timeout, test.valid, err)
+		}
+		if d != test.duration {
+			t.Errorf("%q: expected %v, got %v", test.timeout, test.duration, d)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes int64
+		valid bool
+	}{
+		{"", 0, true},
+		{"1024", 1024, true},
+		{"512M", 512 << 20, true},
+		{"2g", 2 << 30, true},
+		{"10GiB", 10 << 30, true},
+		{" 4KB ", 4 << 10, true},
+		{"1T", 1 << 40, true},
+		{"-1G", 0, false},
+		{"G", 0, false},
+		{"1.5G", 0, false},
+		{"lots", 0, false},
+	}
+	for _, test := range tests {
+		n, err := ParseSize(test.size)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid=%v, got error %v", test.size, test.valid, err)
+		}
+		if n != test.bytes {
+			t.Errorf("%q: expected %d bytes, got %d", test.size, test.bytes, n)
+		}
+	}
+}
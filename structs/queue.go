@@ -0,0 +1,35 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structs
+
+import (
+	"time"
+)
+
+// Build queue states
+const (
+	QueueQueued   = "queued"
+	QueueRunning  = "running"
+	QueueFinished = "finished"
+)
+
+type QueueItem struct {
+	Pipeline   string    `json:"pipeline"`
+	RunID      int       `json:"run_id"`
+	State      string    `json:"state"`
+	QueuedAt   time.Time `json:"queued_at"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structs
+
+import (
+	"time"
+)
+
+// RunStatus is the lifecycle state of a run
+type RunStatus string
+
+const (
+	// RunQueued runs wait for a build slot on the server
+	RunQueued RunStatus = "queued"
+	// RunScheduled runs have been submitted to the scheduler
+	RunScheduled RunStatus = "scheduled"
+	// RunRunning runs are being built by an agent
+	RunRunning RunStatus = "running"
+	// RunCancelling runs have been asked to stop, waiting for the agent
+	RunCancelling RunStatus = "cancelling"
+	RunSucceeded  RunStatus = "succeeded"
+	RunFailed     RunStatus = "failed"
+	RunCancelled  RunStatus = "cancelled"
+	// RunErrored runs could not be built (scheduling or agent errors)
+	RunErrored RunStatus = "errored"
+	// RunTimedOut runs were stopped after exceeding a command or pipeline timeout
+	RunTimedOut RunStatus = "timed_out"
+)
+
+// Run trigger causes
+const (
+	CausePoll    = "poll"
+	CauseWebhook = "webhook"
+	CauseManual  = "manual"
+)
+
+var runTransitions = map[RunStatus][]RunStatus{
+	RunQueued:     {RunScheduled, RunCancelled, RunErrored},
+	RunScheduled:  {RunQueued, RunRunning, RunCancelling, RunSucceeded, RunFailed, RunCancelled, RunErrored, RunTimedOut},
+	RunRunning:    {RunQueued, RunCancelling, RunSucceeded, RunFailed, RunCancelled, RunErrored, RunTimedOut},
+	RunCancelling: {RunSucceeded, RunFailed, RunCancelled, RunErrored, RunTimedOut},
+}
+
+// Finished reports whether the status is final
+func (s RunStatus) Finished() bool {
+	switch s {
+	case RunSucceeded, RunFailed, RunCancelled, RunErrored, RunTimedOut:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether a run can move from s to next. Staying in
+// the same state is allowed, runs without a status can move to any state.
+func (s RunStatus) CanTransition(next RunStatus) bool {
+	if s == "" || s == next {
+		return true
+	}
+	for _, allowed := range runTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+type JobRun struct {
+	Name    string `json:"name"`
+	Stdout  string `json:"stdout"`
+	Stderr  string `json:"stderr"`
+	Success bool   `json:"success"`
+}
+
+type StageRun struct {
+	Name    string   `json:"name"`
+	Success bool     `json:"success"`
+	Jobs    []JobRun `json:"jobs"`
+}
+
+type Run struct {
+	ID           int               `json:"id"`
+	PipelineName string            `json:"pipeline_name"`
+	Status       RunStatus         `json:"status"`
+	Cause        string            `json:"cause,omitempty"`
+	Branch       string            `json:"branch,omitempty"`
+	Tag          string            `json:"tag,omitempty"`
+	Revision     string            `json:"revision,omitempty"`
+	Revisions    map[string]string `json:"revisions,omitempty"`
+	Parameters   map[string]string `json:"parameters,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	BaseEnv      map[string]string `json:"base_env,omitempty"`
+	Clone        string            `json:"clone,omitempty"`
+	Stdout       string            `json:"stdout"`
+	Stderr       string            `json:"stderr"`
+	Success      bool              `json:"success"`
+	Stages       []StageRun        `json:"stages,omitempty"`
+	NomadJobID   string            `json:"nomad_job_id,omitempty"`
+	NomadAddress string            `json:"nomad_address,omitempty"`
+	NomadRegion  string            `json:"nomad_region,omitempty"`
+	NomadStatus  string            `json:"nomad_status,omitempty"`
+	QueuedAt     *time.Time        `json:"queued_at,omitempty"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
+}
+
+// SetStatus moves the run to the given status and records the matching
+// timestamp
+func (r *Run) SetStatus(status RunStatus) {
+	now := time.Now()
+	switch {
+	case status == RunQueued:
+		r.QueuedAt = &now
+		r.StartedAt = nil
+	case status == RunRunning:
+		r.StartedAt = &now
+	case status.Finished():
+		r.FinishedAt = &now
+	}
+	r.Status = status
+	r.Success = status == RunSucceeded
+}
+
+// LogChunk is a piece of build output streamed by agents while running
+type LogChunk struct {
+	Job    string `json:"job"`
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+// RunRequest holds the optional overrides of a manually triggered run.
+// Revision applies to the first material of the pipeline.
+type RunRequest struct {
+	Branch     string            `json:"branch,omitempty"`
+	Revision   string            `json:"revision,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+}
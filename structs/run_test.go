@@ -0,0 +1,83 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structs
+
+import (
+	"testing"
+)
+
+func TestRunStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to RunStatus
+		allowed  bool
+	}{
+		{"", RunSucceeded, true},
+		{RunQueued, RunQueued, true},
+		{RunQueued, RunScheduled, true},
+		{RunQueued, RunRunning, false},
+		{RunScheduled, RunRunning, true},
+		{RunScheduled, RunQueued, true},
+		{RunRunning, RunCancelling, true},
+		{RunRunning, RunSucceeded, true},
+		{RunCancelling, RunCancelled, true},
+		{RunCancelling, RunRunning, false},
+		{RunCancelling, RunQueued, false},
+		{RunSucceeded, RunRunning, false},
+		{RunCancelled, RunQueued, false},
+		{RunFailed, RunSucceeded, false},
+		{RunErrored, RunErrored, true},
+	}
+	for _, test := range tests {
+		if allowed := test.from.CanTransition(test.to); allowed != test.allowed {
+			t.Errorf("%q -> %q: expected allowed=%v", test.from, test.to, test.allowed)
+		}
+	}
+}
+
+func TestRunStatusFinished(t *testing.T) {
+	for _, s := range []RunStatus{RunSucceeded, RunFailed, RunCancelled, RunErrored, RunTimedOut} {
+		if !s.Finished() {
+			t.Errorf("expected %s to be final", s)
+		}
+		if len(runTransitions[s]) != 0 {
+			t.Errorf("final status %s must not have transitions", s)
+		}
+	}
+	for _, s := range []RunStatus{RunQueued, RunScheduled, RunRunning, RunCancelling} {
+		if s.Finished() {
+			t.Errorf("expected %s not to be final", s)
+		}
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	run := &Run{}
+	run.SetStatus(RunQueued)
+	if run.QueuedAt == nil || run.StartedAt != nil {
+		t.Errorf("expected queued time only, got %+v", run)
+	}
+	run.SetStatus(RunRunning)
+	if run.StartedAt == nil || run.FinishedAt != nil {
+		t.Errorf("expected start time, got %+v", run)
+	}
+	run.SetStatus(RunSucceeded)
+	if run.FinishedAt == nil || !run.Success {
+		t.Errorf("expected successful finished run, got %+v", run)
+	}
+	run.SetStatus(RunQueued)
+	if run.StartedAt != nil || run.Success {
+		t.Errorf("expected requeued run to be reset, got %+v", run)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structs
+
+import (
+	"time"
+)
+
+// Role is the access level granted by an API token
+type Role string
+
+const (
+	// RoleViewer tokens can read pipelines, runs, logs and artifacts
+	RoleViewer Role = "viewer"
+	// RoleOperator tokens can also trigger and cancel runs
+	RoleOperator Role = "operator"
+	// RoleAdmin tokens can also manage pipelines, secrets and tokens
+	RoleAdmin Role = "admin"
+	// RoleAgent tokens are issued to build agents for a single run
+	RoleAgent Role = "agent"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Valid reports whether the role is known
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok || r == RoleAgent
+}
+
+type Token struct {
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	Pipeline  string    `json:"pipeline,omitempty"`
+	RunID     int       `json:"run_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Allows reports whether the token grants role on the given pipeline run.
+// Agent tokens can read their pipeline and post results for their run only.
+// Admin tokens can act as agents, e.g. for builds started by hand.
+func (t *Token) Allows(role Role, pipeline string, runId int) bool {
+	if t.Role == RoleAgent {
+		if pipeline == "" || t.Pipeline != pipeline {
+			return false
+		}
+		return role == RoleViewer || (role == RoleAgent && t.RunID == runId)
+	}
+	if role == RoleAgent {
+		return t.Role == RoleAdmin
+	}
+	return roleRanks[t.Role] >= roleRanks[role]
+}
@@ -19,14 +19,13 @@ import (
 	"bytes"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/lxc/go-lxc.v2"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 )
 
 func MinimalEnv() []string {
@@ -45,32 +44,43 @@ func MinimalEnv() []string {
 	}
 }
 
-func PostFileFromContainer(ct *lxc.Container, src, url string) error {
-	uuid, err := UUID()
-	if err != nil {
-		log.Errorf("Failed to generate uuid for temporary file name. Error: %v", err)
-		return err
+// EnvMap converts KEY=VALUE pairs into a map
+func EnvMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 {
+			m[parts[0]] = parts[1]
+		}
 	}
-	dst := filepath.Join("/tmp", uuid)
-	cmd := []string{"cp", src, dst}
-	_, e1 := ct.RunCommandStatus(cmd, lxc.DefaultAttachOptions)
-	if e1 != nil {
-		log.Errorf("Failed to execute: '%s' inside container '%s'", strings.Join(cmd, " "), ct.Name())
-		return e1
+	return m
+}
+
+// EnvList converts a map into KEY=VALUE pairs, sorted by key
+func EnvList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
 	}
+	sort.Strings(list)
+	return list
+}
+
+// PostFile uploads a file as the artifact field of a multipart form
+func PostFile(client *http.Client, file, url, token string) error {
 	bodyBuf := new(bytes.Buffer)
 	bodyWriter := multipart.NewWriter(bodyBuf)
-	fw, err := bodyWriter.CreateFormFile("artifact", dst)
+	fw, err := bodyWriter.CreateFormFile("artifact", file)
 	if err != nil {
 		log.Errorf("Failed to write to buffer. Error: %v", err)
 		return err
 	}
-	rootfs := ct.ConfigItem("lxc.rootfs")[0]
-	fh, e := os.Open(filepath.Join(rootfs, dst))
+	fh, e := os.Open(file)
 	if e != nil {
-		log.Errorf("Failed to open file %s. Error: %v", dst, e)
+		log.Errorf("Failed to open file %s. Error: %v", file, e)
 		return e
 	}
+	defer fh.Close()
 	_, err = io.Copy(fw, fh)
 	if err != nil {
 		log.Errorf("Failed to copy file. Error: %v", err)
@@ -78,9 +88,16 @@ func PostFileFromContainer(ct *lxc.Container, src, url string) error {
 	}
 	contentType := bodyWriter.FormDataContentType()
 	bodyWriter.Close()
-	resp, e2 := http.Post(url, contentType, bodyBuf)
+	req, err := http.NewRequest("POST", url, bodyBuf)
+	if err != nil {
+		log.Errorf("Failed to create http request. Error: %v", err)
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	SetToken(req, token)
+	resp, e2 := client.Do(req)
 	if e2 != nil {
-		log.Errorf("Failed to perform http post. Error: %v", e1)
+		log.Errorf("Failed to perform http post. Error: %v", e2)
 		return e2
 	}
 	defer resp.Body.Close()
@@ -90,28 +107,31 @@ func PostFileFromContainer(ct *lxc.Container, src, url string) error {
 	}
 	return nil
 }
-func CloneAndStartContainer(original, cloned string) (*lxc.Container, error) {
-	orig, err := lxc.NewContainer(original)
-	if err != nil {
-		log.Errorf("Failed to initialize container object. Error: %v", err)
-		return nil, err
-	}
-	if err := orig.Clone(cloned, lxc.CloneOptions{}); err != nil {
-		log.Errorf("Failed to clone container %s as %s. Error: %v", original, cloned, err)
-		return nil, err
-	}
-	ct, err := lxc.NewContainer(cloned)
-	if err != nil {
-		log.Errorf("Failed to clone container %s as %s. Error: %v", original, cloned, err)
-	}
-	if err := ct.Start(); err != nil {
-		log.Errorf("Failed to start cloned container %s. Error: %v", cloned, err)
-		return nil, err
-	}
-	log.Infof("Created container named: %s. Waiting for ip allocation", cloned)
-	if _, err := ct.WaitIPAddresses(30 * time.Second); err != nil {
-		log.Errorf("Failed to while waiting to start the container %s. Error: %v", cloned, err)
-		return nil, err
-	}
-	return ct, nil
+
+// DiskUsage returns the size of the files under a root filesystem, pseudo
+// filesystems (proc, sys, dev) excluded. The root may be a symlink, like the
+// /proc/PID/root of a running container.
+func DiskUsage(root string) (int64, error) {
+	var size int64
+	// the trailing separator makes the walk descend into a symlinked root
+	err := filepath.Walk(filepath.Clean(root)+string(filepath.Separator), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed while walking
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && filepath.Dir(path) == filepath.Clean(root) {
+			switch info.Name() {
+			case "proc", "sys", "dev":
+				return filepath.SkipDir
+			}
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
 }
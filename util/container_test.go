@@ -0,0 +1,56 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gypsy-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "rootfs")
+	for _, d := range []string{"etc", "proc", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]int{"etc/hosts": 100, "tmp/big": 4096, "proc/ignored": 1000}
+	for name, size := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// like /proc/PID/root of a running container
+	link := filepath.Join(dir, "root")
+	if err := os.Symlink(root, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{root, root + "/", link} {
+		usage, err := DiskUsage(path)
+		if err != nil {
+			t.Fatalf("DiskUsage(%s) failed: %v", path, err)
+		}
+		if usage != 4196 {
+			t.Errorf("DiskUsage(%s) = %d, expected 4196", path, usage)
+		}
+	}
+}
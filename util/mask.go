@@ -0,0 +1,101 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// SecretMask replaces secret values in build output
+const SecretMask = "********"
+
+// maxMaskedLine is how much of a line without newline is held back before it
+// is masked and written anyway
+const maxMaskedLine = 64 * 1024
+
+// minMaskedLine is the length from which the lines of multi-line secrets are
+// masked on their own, shorter lines would mask unrelated output
+const minMaskedLine = 4
+
+// SecretMasker returns a function replacing secret values in build output.
+// Lines of multi-line secrets (keys, certificates) are masked on their own as
+// well, since output is masked line by line.
+func SecretMasker(values map[string]string) func(string) string {
+	var secrets []string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		secrets = append(secrets, v)
+		if !strings.Contains(v, "\n") {
+			continue
+		}
+		for _, line := range strings.Split(v, "\n") {
+			if line = strings.TrimSpace(line); len(line) >= minMaskedLine {
+				secrets = append(secrets, line)
+			}
+		}
+	}
+	if len(secrets) == 0 {
+		return func(s string) string { return s }
+	}
+	// longer values first, so secrets containing others are fully masked
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	var oldnew []string
+	for _, v := range secrets {
+		oldnew = append(oldnew, v, SecretMask)
+	}
+	return strings.NewReplacer(oldnew...).Replace
+}
+
+// MaskingWriter masks secret values in whole lines before writing them to W,
+// so secrets split across writes are masked too. Close writes the last line
+// when it has no newline.
+type MaskingWriter struct {
+	W    io.Writer
+	Mask func(string) string
+	buf  []byte
+}
+
+func (m *MaskingWriter) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	end := bytes.LastIndexByte(m.buf, '\n') + 1
+	if end == 0 && len(m.buf) >= maxMaskedLine {
+		end = len(m.buf)
+	}
+	if end == 0 {
+		return len(p), nil
+	}
+	lines := string(m.buf[:end])
+	m.buf = append(m.buf[:0], m.buf[end:]...)
+	if _, err := io.WriteString(m.W, m.Mask(lines)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the remaining output
+func (m *MaskingWriter) Close() error {
+	if len(m.buf) == 0 {
+		return nil
+	}
+	rest := string(m.buf)
+	m.buf = nil
+	_, err := io.WriteString(m.W, m.Mask(rest))
+	return err
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Descendants returns the processes started by pid, its children and their
+// children, as found in /proc
+func Descendants(pid int) ([]int, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]int)
+	for _, stat := range stats {
+		data, err := ioutil.ReadFile(stat)
+		if err != nil {
+			// the process exited meanwhile
+			continue
+		}
+		// pid (comm) state ppid ..., comm may contain spaces and parentheses
+		s := string(data)
+		fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		child, err1 := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		parent, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		children[parent] = append(children[parent], child)
+	}
+	var pids []int
+	queue := children[pid]
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		pids = append(pids, p)
+		queue = append(queue, children[p]...)
+	}
+	return pids, nil
+}
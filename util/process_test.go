@@ -0,0 +1,56 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDescendants(t *testing.T) {
+	// sh starts a subshell which starts sleep, both outlive their parent's
+	// next command
+	cmd := exec.Command("/bin/sh", "-c", "(sleep 30; true) & sleep 30; true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	var pids []int
+	for i := 0; i < 50; i++ {
+		var err error
+		if pids, err = Descendants(cmd.Process.Pid); err != nil {
+			t.Fatal(err)
+		}
+		if len(pids) >= 3 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// the subshell, its sleep and the shell's sleep
+	if len(pids) != 3 {
+		t.Fatalf("expected 3 descendants, got %v", pids)
+	}
+	for _, pid := range pids {
+		if pid == cmd.Process.Pid {
+			t.Errorf("descendants include the process itself")
+		}
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"net/http"
 )
 
 func UUID() (string, error) {
@@ -40,3 +41,10 @@ func Itob(i uint64) []byte {
 func Btoi(b []byte) uint64 {
 	return binary.BigEndian.Uint64(b)
 }
+
+// SetToken adds a bearer token to a request to the gypsy server
+func SetToken(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
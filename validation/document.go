@@ -0,0 +1,199 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/structs"
+	yamlv2 "gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var linePattern = regexp.MustCompile(`line (\d+)`)
+
+// Result holds the outcome of validating a pipeline configuration document
+type Result struct {
+	Pipeline *structs.Pipeline
+	Errors   Errors
+	// Warnings are problems that do not prevent building the pipeline, e.g.
+	// unknown fields
+	Warnings Errors
+	root     *yaml.Node
+}
+
+// Document validates a pipeline configuration document: its syntax, fields
+// and the pipeline it describes. Errors and warnings carry the position of
+// the offending field, when known.
+func Document(content []byte) *Result {
+	r := new(Result)
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		r.Errors = append(r.Errors, yamlError(err)...)
+		return r
+	}
+	if len(doc.Content) > 0 {
+		r.root = doc.Content[0]
+	}
+	pipeline := new(structs.Pipeline)
+	// decode like the server and agents do
+	if err := yamlv2.Unmarshal(content, pipeline); err != nil {
+		r.Errors = append(r.Errors, yamlError(err)...)
+		return r
+	}
+	r.Pipeline = pipeline
+	if r.root != nil {
+		r.unknownFields(r.root, reflect.TypeOf(structs.Pipeline{}), "")
+	}
+	for _, err := range Pipeline(pipeline) {
+		r.Add(err.Field, "%s", err.Message)
+	}
+	return r
+}
+
+// Add records an error for a field, positioned at the field or its closest
+// parent present in the document
+func (r *Result) Add(field, format string, args ...interface{}) {
+	err := Error{Field: field, Message: fmt.Sprintf(format, args...)}
+	err.Line, err.Column = r.locate(field)
+	r.Errors = append(r.Errors, err)
+}
+
+func (r *Result) warn(node *yaml.Node, field, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, Error{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+		Line:    node.Line,
+		Column:  node.Column,
+	})
+}
+
+// yamlError converts yaml parse and type errors into errors with lines
+func yamlError(err error) Errors {
+	var messages []string
+	switch e := err.(type) {
+	case *yaml.TypeError:
+		messages = e.Errors
+	case *yamlv2.TypeError:
+		messages = e.Errors
+	default:
+		messages = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+	errs := make(Errors, len(messages))
+	for i, message := range messages {
+		errs[i].Message = message
+		if m := linePattern.FindStringSubmatch(message); m != nil {
+			errs[i].Line, _ = strconv.Atoi(m[1])
+			errs[i].Message = strings.TrimPrefix(strings.TrimPrefix(message, m[0]), ": ")
+		}
+	}
+	return errs
+}
+
+// fieldName returns the key a struct field is decoded from
+func fieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("yaml"), ",")[0]; tag != "" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// unknownFields warns about mapping keys that do not match a field of t
+func (r *Result) unknownFields(node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				fields[fieldName(f)] = f.Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			ft, ok := fields[key.Value]
+			if !ok {
+				r.warn(key, join(path, key.Value), "unknown field '%s'", key.Value)
+				continue
+			}
+			r.unknownFields(value, ft, join(path, key.Value))
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			r.unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// locate returns the position of a field path like stages[0].jobs[1].name
+func (r *Result) locate(field string) (int, int) {
+	node := r.root
+	if node == nil {
+		return 0, 0
+	}
+	line, column := node.Line, node.Column
+	for _, part := range strings.Split(field, ".") {
+		if part == "" {
+			continue
+		}
+		name := part
+		var indexes []int
+		if i := strings.Index(part, "["); i >= 0 {
+			name = part[:i]
+			for _, idx := range strings.Split(strings.Trim(part[i:], "[]"), "][") {
+				n, _ := strconv.Atoi(idx)
+				indexes = append(indexes, n)
+			}
+		}
+		key, value := mappingValue(node, name)
+		if value == nil {
+			return line, column
+		}
+		line, column = key.Line, key.Column
+		node = value
+		for _, idx := range indexes {
+			if node.Kind != yaml.SequenceNode || idx >= len(node.Content) {
+				return line, column
+			}
+			node = node.Content[idx]
+			line, column = node.Line, node.Column
+		}
+	}
+	return line, column
+}
+
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"testing"
+)
+
+const document = `name: gypsy
+colour: blue
+stages:
+  - name: build
+    jobs:
+      - container: ubuntu
+        scripts:
+          - command: make
+        flavour: vanilla
+      - scripts:
+          - command: make
+`
+
+func TestDocumentPositions(t *testing.T) {
+	r := Document([]byte(document))
+	if r.Pipeline == nil || r.Pipeline.Name != "gypsy" {
+		t.Fatalf("expected the pipeline to be decoded, got %+v", r.Pipeline)
+	}
+	expected := Error{Field: "stages[0].jobs[1].container", Message: "is required when the pipeline has no container", Line: 10, Column: 9}
+	if len(r.Errors) != 1 || r.Errors[0] != expected {
+		t.Errorf("expected error %+v, got %+v", expected, r.Errors)
+	}
+	warnings := Errors{
+		{Field: "colour", Message: "unknown field 'colour'", Line: 2, Column: 1},
+		{Field: "stages[0].jobs[0].flavour", Message: "unknown field 'flavour'", Line: 9, Column: 9},
+	}
+	if len(r.Warnings) != len(warnings) {
+		t.Fatalf("expected warnings %+v, got %+v", warnings, r.Warnings)
+	}
+	for i, w := range warnings {
+		if r.Warnings[i] != w {
+			t.Errorf("expected warning %+v, got %+v", w, r.Warnings[i])
+		}
+	}
+}
+
+func TestDocumentLocateMissingField(t *testing.T) {
+	r := Document([]byte("name: gypsy\nscripts:\n  - command: make\n"))
+	// the container field is absent, the error points at the document
+	if !hasError(r.Errors, "container") {
+		t.Fatalf("expected a container error, got %+v", r.Errors)
+	}
+	if line, column := r.locate("stages[3].jobs[0]"); line != 1 || column != 1 {
+		t.Errorf("expected unknown fields to be located at the document, got %d:%d", line, column)
+	}
+	if line, column := r.locate("scripts[0].command"); line != 3 || column != 5 {
+		t.Errorf("expected scripts[0].command at 3:5, got %d:%d", line, column)
+	}
+}
+
+func TestDocumentSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		line    int
+	}{
+		{"name: gypsy\nstages: [\n", 2},
+		{"name: gypsy\ncontainer: ubuntu\nstages: 3\n", 3},
+	}
+	for _, test := range tests {
+		r := Document([]byte(test.content))
+		if r.Pipeline != nil {
+			t.Errorf("%q: expected no pipeline", test.content)
+		}
+		if len(r.Errors) == 0 || r.Errors[0].Line != test.line {
+			t.Errorf("%q: expected an error on line %d, got %+v", test.content, test.line, r.Errors)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Validates pipeline configurations
+package validation
+
+import (
+	"fmt"
+	"github.com/ranjib/gypsy/executor"
+	"github.com/ranjib/gypsy/material"
+	"github.com/ranjib/gypsy/structs"
+	"strings"
+)
+
+// Error is a problem with a pipeline field. Field is the path of the field in
+// the pipeline configuration, e.g. stages[0].jobs[1].container. Line and
+// Column locate it in the configuration document, when known.
+type Error struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+func (e Error) String() string {
+	message := e.Message
+	if e.Field != "" {
+		message = e.Field + ": " + message
+	}
+	switch {
+	case e.Column > 0:
+		message = fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, message)
+	case e.Line > 0:
+		message = fmt.Sprintf("line %d: %s", e.Line, message)
+	}
+	return message
+}
+
+// Errors lists all problems found in a pipeline
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+type validator struct {
+	errors Errors
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, Error{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Pipeline validates a pipeline configuration, returning nil for valid
+// pipelines
+func Pipeline(pipeline *structs.Pipeline) Errors {
+	v := new(validator)
+	switch {
+	case pipeline.Name == "":
+		v.add("name", "is required")
+	case strings.ContainsAny(pipeline.Name, "/?#% "):
+		v.add("name", "must not contain '/', '?', '#', '%%' or spaces")
+	}
+	v.materials(pipeline.Materials)
+	if _, err := structs.ParseTimeout(pipeline.Timeout); err != nil {
+		v.add("timeout", "%v", err)
+	}
+	artifacts := make(map[string]string)
+	v.artifacts("artifacts", pipeline.Artifacts, artifacts)
+	// local builds can run without a root filesystem
+	needsContainer := pipeline.Executor != "local"
+	if !executor.Known(pipeline.Executor) {
+		v.add("executor", "unknown executor '%s', expected one of %s", pipeline.Executor, strings.Join(executor.Types(), ", "))
+	}
+	if len(pipeline.Stages) == 0 {
+		if pipeline.Container == "" && needsContainer {
+			v.add("container", "is required")
+		}
+		v.commands("scripts", pipeline.Scripts)
+	}
+	for i, stage := range pipeline.Stages {
+		for j, job := range stage.Jobs {
+			field := fmt.Sprintf("stages[%d].jobs[%d]", i, j)
+			if job.Container == "" && pipeline.Container == "" && needsContainer {
+				v.add(field+".container", "is required when the pipeline has no container")
+			}
+			v.commands(field+".scripts", job.Scripts)
+			v.artifacts(field+".artifacts", job.Artifacts, artifacts)
+		}
+	}
+	if len(pipeline.Stages) > 0 {
+		if _, err := pipeline.OrderedStages(); err != nil {
+			v.add("stages", "%v", err)
+		}
+	}
+	if !CloneMode(pipeline.Clone) {
+		v.add("clone", "unknown clone mode '%s', expected one of %s", pipeline.Clone, strings.Join(executor.CloneModes, ", "))
+	}
+	if pipeline.WebhookSecret != "" {
+		if _, ok := structs.SecretRef(pipeline.WebhookSecret); !ok {
+			v.add("webhook_secret", "must reference a secret of the secret store (%sNAME)", structs.SecretPrefix)
+		}
+	}
+	v.resources(pipeline.Resources)
+	v.nomad(pipeline.Nomad)
+	return v.errors
+}
+
+// CloneMode reports whether mode is a known container clone mode. Empty
+// modes use the default.
+func CloneMode(mode string) bool {
+	if mode == "" {
+		return true
+	}
+	for _, m := range executor.CloneModes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) resources(resources structs.Resources) {
+	if _, err := structs.ParseSize(resources.Memory); err != nil {
+		v.add("resources.memory", "%v", err)
+	}
+	if _, err := structs.ParseSize(resources.Disk); err != nil {
+		v.add("resources.disk", "%v", err)
+	}
+	if resources.CPUShares < 0 {
+		v.add("resources.cpu_shares", "must not be negative")
+	}
+	if resources.Pids < 0 {
+		v.add("resources.pids", "must not be negative")
+	}
+}
+
+func (v *validator) nomad(nomad structs.NomadConfig) {
+	for i, constraint := range nomad.Constraints {
+		if constraint.Attribute == "" && constraint.Operator != "distinct_hosts" {
+			v.add(fmt.Sprintf("nomad.constraints[%d].attribute", i), "is required")
+		}
+	}
+	if nomad.Resources.CPU < 0 {
+		v.add("nomad.resources.cpu", "must not be negative")
+	}
+	if nomad.Resources.MemoryMB < 0 {
+		v.add("nomad.resources.memory_mb", "must not be negative")
+	}
+	if nomad.Resources.DiskMB < 0 {
+		v.add("nomad.resources.disk_mb", "must not be negative")
+	}
+}
+
+func (v *validator) materials(materials []structs.Material) {
+	types := material.Types()
+	for i, m := range materials {
+		field := fmt.Sprintf("materials[%d]", i)
+		known := false
+		for _, t := range types {
+			if m.Type == t {
+				known = true
+			}
+		}
+		if !known {
+			v.add(field+".type", "unknown material type '%s', expected one of %s", m.Type, strings.Join(types, ", "))
+		}
+		if m.URI == "" {
+			v.add(field+".uri", "is required")
+		}
+	}
+}
+
+// artifacts checks artifact names are unique within a run, seen maps names to
+// the field that declared them
+func (v *validator) artifacts(field string, artifacts []structs.Artifact, seen map[string]string) {
+	for i, artifact := range artifacts {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if artifact.Name == "" {
+			v.add(f+".name", "is required")
+			continue
+		}
+		if previous, ok := seen[artifact.Name]; ok {
+			v.add(f+".name", "duplicate artifact name '%s', already used by %s", artifact.Name, previous)
+			continue
+		}
+		seen[artifact.Name] = f
+		if artifact.Path == "" {
+			v.add(f+".path", "is required")
+		}
+	}
+}
+
+func (v *validator) commands(field string, commands []structs.Command) {
+	for i, cmd := range commands {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		set := 0
+		for _, given := range []bool{cmd.Command != "", cmd.Script != "", len(cmd.Args) > 0} {
+			if given {
+				set++
+			}
+		}
+		if set != 1 {
+			v.add(f, "exactly one of command, script or args is required")
+		}
+		switch cmd.Shell {
+		case "", "bash", "sh":
+		case "none":
+			if cmd.Script != "" {
+				v.add(f+".shell", "scripts need a shell")
+			}
+		default:
+			v.add(f+".shell", "unsupported shell '%s'", cmd.Shell)
+		}
+		if _, err := structs.ParseTimeout(cmd.Timeout); err != nil {
+			v.add(f+".timeout", "%v", err)
+		}
+	}
+}
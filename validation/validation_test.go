@@ -0,0 +1,78 @@
+// Copyright 2015 Ranjib Dey.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"github.com/ranjib/gypsy/structs"
+	"testing"
+)
+
+func hasError(errs Errors, field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPipelineCloneMode(t *testing.T) {
+	tests := []struct {
+		clone string
+		valid bool
+	}{
+		{"", true},
+		{"copy", true},
+		{"snapshot", true},
+		{"overlayfs", true},
+		{"lvm", true},
+		{"sideways", false},
+	}
+	for _, test := range tests {
+		pipeline := &structs.Pipeline{
+			Name:      "gypsy",
+			Container: "ubuntu",
+			Scripts:   []structs.Command{{Command: "make"}},
+			Clone:     test.clone,
+		}
+		errs := Pipeline(pipeline)
+		if invalid := hasError(errs, "clone"); invalid == test.valid {
+			t.Errorf("clone %q: expected valid=%v, got errors %v", test.clone, test.valid, errs)
+		}
+	}
+}
+
+func TestPipelineWebhookSecret(t *testing.T) {
+	tests := []struct {
+		secret string
+		valid  bool
+	}{
+		{"", true},
+		{"secret:github-hook", true},
+		{"plain text", false},
+	}
+	for _, test := range tests {
+		pipeline := &structs.Pipeline{
+			Name:          "gypsy",
+			Container:     "ubuntu",
+			Scripts:       []structs.Command{{Command: "make"}},
+			WebhookSecret: test.secret,
+		}
+		errs := Pipeline(pipeline)
+		if invalid := hasError(errs, "webhook_secret"); invalid == test.valid {
+			t.Errorf("webhook_secret %q: expected valid=%v, got errors %v", test.secret, test.valid, errs)
+		}
+	}
+}